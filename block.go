@@ -0,0 +1,98 @@
+package cell
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ErrInvalidBlock is returned when a reply is not an IEEE 488.2 block,
+// errors wrapping it tell what exactly was wrong
+var ErrInvalidBlock = errors.New("cell: invalid IEEE 488.2 block")
+
+// ParseBlock returns the data of an IEEE 488.2 definite length block
+// #<n><length><data>. An indefinite length block #0<data> runs to the end
+// of the reply, its terminating newline excluded
+func ParseBlock(reply []byte) ([]byte, error) {
+	reply = bytes.TrimLeft(reply, " \t\r\n")
+	if len(reply) < 2 || reply[0] != '#' {
+		return nil, fmt.Errorf("%w: missing '#' header", ErrInvalidBlock)
+	}
+	digits := int(reply[1] - '0')
+	if digits < 0 || digits > 9 {
+		return nil, fmt.Errorf("%w: invalid digit count %q", ErrInvalidBlock, reply[1])
+	}
+	if digits == 0 {
+		return bytes.TrimSuffix(bytes.TrimSuffix(reply[2:], []byte("\n")), []byte("\r")), nil
+	}
+	if len(reply) < 2+digits {
+		return nil, fmt.Errorf("%w: truncated length field", ErrInvalidBlock)
+	}
+	length, err := strconv.Atoi(string(reply[2 : 2+digits]))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("%w: invalid length %q", ErrInvalidBlock, reply[2:2+digits])
+	}
+	data := reply[2+digits:]
+	if len(data) < length {
+		return nil, fmt.Errorf("%w: %d data bytes, header announced %d", ErrInvalidBlock, len(data), length)
+	}
+	return data[:length], nil
+}
+
+// QuerySCPIBlock sends SCPI query whose reply is an IEEE 488.2 block,
+// such as trace or file queries, and returns the block data
+func (cl *CellAdvisor) QuerySCPIBlock(scpicmd string) ([]byte, error) {
+	return cl.QuerySCPIBlockContext(context.Background(), scpicmd)
+}
+
+// QuerySCPIBlockContext is like QuerySCPIBlock, but gives up once ctx is done
+func (cl *CellAdvisor) QuerySCPIBlockContext(ctx context.Context, scpicmd string) ([]byte, error) {
+	if !isSCPIQuery(scpicmd) {
+		return nil, fmt.Errorf("cell: %s: not a query", scpicmd)
+	}
+	ret, err := cl.TransactContext(ctx, 0x61, scpicmd+"\n")
+	if err != nil {
+		return nil, err
+	}
+	return ParseBlock(ret)
+}
+
+// DecodeReal32 decodes REAL,32 block data in given byte order
+func DecodeReal32(data []byte, order binary.ByteOrder) ([]float32, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("%w: %d bytes is not a multiple of REAL,32 size", ErrInvalidBlock, len(data))
+	}
+	values := make([]float32, len(data)/4)
+	for i := range values {
+		values[i] = math.Float32frombits(order.Uint32(data[i*4:]))
+	}
+	return values, nil
+}
+
+// DecodeReal64 decodes REAL,64 block data in given byte order
+func DecodeReal64(data []byte, order binary.ByteOrder) ([]float64, error) {
+	if len(data)%8 != 0 {
+		return nil, fmt.Errorf("%w: %d bytes is not a multiple of REAL,64 size", ErrInvalidBlock, len(data))
+	}
+	values := make([]float64, len(data)/8)
+	for i := range values {
+		values[i] = math.Float64frombits(order.Uint64(data[i*8:]))
+	}
+	return values, nil
+}
+
+// DecodeInt16 decodes INT,16 block data in given byte order
+func DecodeInt16(data []byte, order binary.ByteOrder) ([]float32, error) {
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%w: %d bytes is not a multiple of INT,16 size", ErrInvalidBlock, len(data))
+	}
+	values := make([]float32, len(data)/2)
+	for i := range values {
+		values[i] = float32(int16(order.Uint16(data[i*2:])))
+	}
+	return values, nil
+}
@@ -0,0 +1,84 @@
+package cell
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseBlock(t *testing.T) {
+	for reply, want := range map[string]string{
+		"#15hello\n":       "hello",
+		"#2100123456789":   "0123456789",
+		"\n#3004abcdextra": "abcd",
+		"#0raw data\r\n":   "raw data",
+		"#10":              "",
+	} {
+		got, err := ParseBlock([]byte(reply))
+		if err != nil || string(got) != want {
+			t.Fatalf("ParseBlock(%q) = %q, %v, want %q", reply, got, err, want)
+		}
+	}
+	for _, reply := range []string{"", "5hello", "#", "#x", "#9123", "#15abc", "#2-1"} {
+		if _, err := ParseBlock([]byte(reply)); !errors.Is(err, ErrInvalidBlock) {
+			t.Fatalf("ParseBlock(%q) error = %v, want %v", reply, err, ErrInvalidBlock)
+		}
+	}
+}
+
+func TestDecodeBlockFormats(t *testing.T) {
+	want := []float64{-100.5, 0, 3.25}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var real32, real64, int16s bytes.Buffer
+		for _, v := range want {
+			binary.Write(&real32, order, float32(v))
+			binary.Write(&real64, order, v)
+			binary.Write(&int16s, order, int16(math.Trunc(v)))
+		}
+		f32, err := DecodeReal32(real32.Bytes(), order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f64, err := DecodeReal64(real64.Bytes(), order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i16, err := DecodeInt16(int16s.Bytes(), order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range want {
+			if float64(f32[i]) != v || f64[i] != v || float64(i16[i]) != math.Trunc(v) {
+				t.Fatalf("%v: decoded %v %v %v, want %v", order, f32[i], f64[i], i16[i], v)
+			}
+		}
+	}
+	if _, err := DecodeReal32(make([]byte, 5), binary.LittleEndian); err == nil {
+		t.Fatal("DecodeReal32 accepted a partial value")
+	}
+}
+
+func TestQuerySCPIBlock(t *testing.T) {
+	var trace bytes.Buffer
+	binary.Write(&trace, binary.LittleEndian, []float32{-90, -80})
+	cl := fakeSCPIDevice(t, func(scpicmd string) string {
+		if scpicmd == "TRAC:DATA? TRACE1" {
+			return "#18" + trace.String() + "\n"
+		}
+		return ""
+	})
+	defer cl.Close()
+	data, err := cl.QuerySCPIBlock("TRAC:DATA? TRACE1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := DecodeReal32(data, binary.LittleEndian)
+	if err != nil || len(values) != 2 || values[0] != -90 || values[1] != -80 {
+		t.Fatalf("trace = %v, %v", values, err)
+	}
+	if _, err := cl.QuerySCPIBlock("TRAC:DATA TRACE1"); err == nil {
+		t.Fatal("QuerySCPIBlock accepted a command which is not a query")
+	}
+}
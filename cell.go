@@ -2,183 +2,344 @@
 package cell
 
 import (
-	"bufio"
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net"
-	"regexp"
-	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	//JDProtocolPort represents Port number which CellAdviosr TCP Connection uses
 	JDProtocolPort = ":66"
+	//ErrTimeout is returned when CellAdvisor does not answer before the deadline
+	ErrTimeout = errors.New("cell: request timed out")
 )
 
 // CellAdvisor represents connection status with JDSU CellAdvisor devices
-// It could only made by NewCellAdvisor(ip string) function
+// It could only made by NewCellAdvisor(ip string) or
+// NewCellAdvisorWithOptions(ip string, opts DialOptions) function
+//
+// A CellAdvisor is safe for concurrent use, every request/response
+// pair made by its methods runs as one transaction on the connection
 type CellAdvisor struct {
-	ip     string
-	reader *bufio.Reader
-	writer *bufio.Writer
-}
-
-// InterferencePower represents interferences data given by CellAdvisor
-type InterferencePower struct {
-	// Unit represents real unit of following float array
-	Unit string `json:"Unit"`
-	// PowerTrace is measured power array based on frequency plan
-	Powertrace []float32 `json:"Powertrace"`
+	// mu serializes access to the connection
+	mu      sync.Mutex
+	ip      string
+	options DialOptions
+	conn    net.Conn
+	decoder *Decoder
+	// checkErrors drains SCPI error queue after every SendSCPI
+	checkErrors bool
+	// info caches *IDN? answer of current connection
+	info *DeviceInfo
+	// maxPayloadSize splits outgoing payloads over several frames
+	maxPayloadSize int
+	// opcPollInterval and opcTimeout pace WaitOperationComplete
+	opcPollInterval time.Duration
+	opcTimeout      time.Duration
+	// checksumPolicy and checksumRetries handle corrupted replies
+	checksumPolicy  ChecksumPolicy
+	checksumRetries int
+	// checksumFailures counts mismatching frames since creation
+	checksumFailures atomic.Uint64
+	// demux reads the connection in background when demuxEnabled
+	demuxEnabled bool
+	demux        *demuxer
+	// subscribers receive unsolicited messages read by demux
+	subscribersMu sync.Mutex
+	subscribers   map[chan Event]struct{}
 }
 
 // SendMessage send single cmd byte, and data strings and returing
 // the number of bytes send and followed error if any
-func (cl CellAdvisor) SendMessage(cmd byte, data string) (int, error) {
+// data longer than the maximum payload size is split over several frames
+func (cl *CellAdvisor) SendMessage(cmd byte, data string) (int, error) {
+	return cl.SendMessageContext(context.Background(), cmd, data)
+}
+
+// SendMessageContext is like SendMessage, but gives up once ctx is done.
+// It returns ErrTimeout when ctx deadline passes, ctx.Err() when canceled
+func (cl *CellAdvisor) SendMessageContext(ctx context.Context, cmd byte, data string) (int, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.sendMessage(ctx, cmd, data)
+}
 
-	sendingMsg := []byte{'C', cmd, 0x01, 0x01}
-	if data != "" {
-		sendingMsg = append(sendingMsg, []byte(data)...)
+func (cl *CellAdvisor) sendMessage(ctx context.Context, cmd byte, data string) (int, error) {
+
+	frames, err := SplitFrames(cmd, []byte(data), cl.maxPayloadSize)
+	if err != nil {
+		return 0, err
+	}
+	var message []byte
+	for _, f := range frames {
+		frame, err := f.MarshalBinary()
+		if err != nil {
+			return 0, err
+		}
+		message = append(message, frame...)
 	}
-	//append checksum
-	sendingMsg = append(sendingMsg, getChecksum(sendingMsg))
-	sendingMsg = maskingCommandCharacter(sendingMsg)
-	sendingMsg = append(append([]byte{0x7f}, sendingMsg...), 0x7e)
 
-	num, err := cl.writer.Write(sendingMsg)
-	//num, err := fmt.Fprintf(cl.writer, string(sendingMsg))
-	err = cl.writer.Flush()
-	return num, err
+	defer watchContext(ctx, cl.conn.SetWriteDeadline)()
+	num, err := cl.conn.Write(message)
+	return num, contextError(ctx, err)
 }
 
 // GetMessage receive data right after it send request with SendMessage
 // it returns the data and followed error if any
-func (cl CellAdvisor) GetMessage() ([]byte, error) {
+// it returns ErrDemuxActive while demultiplexing, see SetDemux
+func (cl *CellAdvisor) GetMessage() ([]byte, error) {
+	return cl.GetMessageContext(context.Background())
+}
+
+// GetMessageContext is like GetMessage, but gives up once ctx is done.
+// It returns ErrTimeout when ctx deadline passes, ctx.Err() when canceled.
+// A reply cut in the middle or holding a broken frame leaves the stream
+// out of step, the connection should be Reinitialized then,
+// as IsConnectionError tells
+func (cl *CellAdvisor) GetMessageContext(ctx context.Context) ([]byte, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.getMessage(ctx)
+}
 
+func (cl *CellAdvisor) getMessage(ctx context.Context) ([]byte, error) {
+	if cl.demux != nil {
+		return nil, ErrDemuxActive
+	}
+
+	defer watchContext(ctx, cl.conn.SetReadDeadline)()
 	bufResult := []byte{}
-	messageContinue := true
-	for messageContinue {
-		ret, err := cl.reader.ReadBytes(0x7e)
+	var checksumErr *ChecksumError
+	for received := 1; ; received++ {
+		frame, err := cl.decoder.Decode()
 		if err != nil {
-			return nil, err
+			return nil, contextError(ctx, err)
 		}
-		command, checksum := unmaskingCommandCharacter(ret[5 : len(ret)-1])
-		if realchecksum := getChecksum(append(ret[1:5], command...)); realchecksum != checksum {
-			log.Printf("checksum required to be: %c, but %c", realchecksum, checksum)
+		if received > int(frame.Total) && received > 1 {
+			return nil, fmt.Errorf("%w: frame %d of %d", ErrTooManyFrames, received, frame.Total)
+		}
+		if realchecksum := frame.Sum(); realchecksum != frame.Checksum {
+			cl.checksumFailures.Add(1)
+			if cl.checksumPolicy == ChecksumLog {
+				log.Printf("checksum required to be: %c, but %c", realchecksum, frame.Checksum)
+			} else if checksumErr == nil {
+				checksumErr = &ChecksumError{Command: frame.Command, Expected: realchecksum, Actual: frame.Checksum}
+			}
 		}
-		bufResult = append(bufResult, command...)
-		if ret[3] <= ret[4]+1 {
-			messageContinue = false
+		bufResult = append(bufResult, frame.Payload...)
+		if frame.Last() {
+			break
 		}
 	}
 
+	// the whole message is read even if corrupted, keeping the stream in step
+	if checksumErr != nil {
+		return nil, checksumErr
+	}
 	return bufResult, nil
 }
 
-func (cl *CellAdvisor) Reinitialize() {
-	cl.initCellAdvisor()
+// SetMaxPayloadSize sets the largest payload sent in a single frame,
+// longer payloads are split over several frames. Zero or less disables splitting
+func (cl *CellAdvisor) SetMaxPayloadSize(size int) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.maxPayloadSize = size
 }
 
-func (cl *CellAdvisor) initCellAdvisor() {
-
-	conn, err := net.Dial("tcp", cl.ip+JDProtocolPort)
-	if err != nil {
-		panic(err)
-	}
-
-	cl.reader, cl.writer = bufio.NewReader(conn), bufio.NewWriter(conn)
+// Transact sends cmd with payload and reads the reply as one unit,
+// no other request can use the connection in between.
+// While demultiplexing (see SetDemux) requests are pipelined instead:
+// several may be in flight, each getting the reply matching its order
+func (cl *CellAdvisor) Transact(cmd byte, payload string) ([]byte, error) {
+	return cl.TransactContext(context.Background(), cmd, payload)
 }
 
-// GetScreen returning current devices jpeg screenshot
-func (cl CellAdvisor) GetScreen() ([]byte, error) {
-	cl.SendMessage(0x60, "")
-	return cl.GetMessage()
+// TransactContext is like Transact, but gives up once ctx is done
+func (cl *CellAdvisor) TransactContext(ctx context.Context, cmd byte, payload string) ([]byte, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.transact(ctx, cmd, payload, true)
 }
 
-// GetStatusMessage returning a heartbeat signal message from CellAdvisor
-func (cl CellAdvisor) GetStatusMessage() (string, error) {
-	cl.SendMessage(0x50, "")
-	ret, err := cl.GetMessage()
-	return string(ret), err
+// transact runs one request/response pair with cl.mu held, repeating it
+// on checksum mismatch as long as ChecksumRetry policy allows.
+// When pipelined and demultiplexing, cl.mu is released while waiting
+// for the reply, so that other requests can be sent meanwhile
+func (cl *CellAdvisor) transact(ctx context.Context, cmd byte, payload string, pipelined bool) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		ret, err := cl.roundTrip(ctx, cmd, payload, pipelined)
+		if _, ok := err.(*ChecksumError); ok && cl.checksumPolicy == ChecksumRetry && attempt < cl.checksumRetries {
+			continue
+		}
+		return ret, err
+	}
 }
 
-// GetInterferencePower returning current interference power array
-// with json format
-func (cl CellAdvisor) GetInterferencePower() (*InterferencePower, error) {
-	cl.SendMessage(0x83, "")
-	ret, err := cl.GetMessage()
-	if err != nil {
+func (cl *CellAdvisor) roundTrip(ctx context.Context, cmd byte, payload string, pipelined bool) ([]byte, error) {
+	if cl.demux != nil {
+		return cl.roundTripDemux(ctx, cmd, payload, pipelined)
+	}
+	if _, err := cl.sendMessage(ctx, cmd, payload); err != nil {
 		return nil, err
 	}
+	return cl.getMessage(ctx)
+}
 
-	reunit := regexp.MustCompile("Unit=\"([a-zA-Z]+)\"")
-	repower := regexp.MustCompile("P([0-9]+)+=\"(-*[0-9]+.[0-9]+)\"")
-
-	unit := reunit.FindStringSubmatch(string(ret))
-	trace := repower.FindAllStringSubmatch(string(ret), -1)
-	if trace == nil || unit == nil {
-		return nil, errors.New("input is not an interference XML source")
+// watchContext applies ctx deadline through setDeadline, one of the
+// connection's SetReadDeadline or SetWriteDeadline, and interrupts
+// blocked I/O once ctx is canceled,
+// the returned function must be called when the operation ends
+func watchContext(ctx context.Context, setDeadline func(time.Time) error) func() {
+	deadline, _ := ctx.Deadline()
+	setDeadline(deadline)
+	if ctx.Done() == nil {
+		return func() {}
 	}
 
-	powertrace := make([]float32, len(trace))
-	for i, v := range trace {
-		convertfloatResult, err := strconv.ParseFloat(v[len(v)-1], 32)
-		powertrace[i] = float32(convertfloatResult)
-		if err != nil {
-			return nil, err
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			// any time in the past wakes up pending I/O
+			setDeadline(time.Unix(1, 0))
+		case <-stop:
 		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		setDeadline(time.Time{})
+	}
+}
+
+// contextError turns I/O errors caused by ctx into ErrTimeout or ctx.Err()
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.Canceled {
+		return ctx.Err()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
 	}
-	return &InterferencePower{Unit: unit[1], Powertrace: powertrace}, nil
+	return err
 }
 
-// SendSCPI sends SCPI commands to CellAdvisor devices
-// (http://en.wikipedia.org/wiki/Standard_Commands_for_Programmable_Instruments)
-func (cl CellAdvisor) SendSCPI(scpicmd string) (int, error) {
-	return cl.SendMessage(0x61, scpicmd+"\n")
+// Reinitialize drops current connection and dials CellAdvisor again
+// it returns a *DialError if the device could not be reached,
+// and an error wrapping ErrTimeout if it did not answer *IDN? in time
+func (cl *CellAdvisor) Reinitialize() error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.close()
+	return cl.initCellAdvisor(context.Background())
 }
 
-func maskingCommandCharacter(data []byte) []byte {
-	var result []byte
-	for _, character := range data {
-		switch character {
-		case 0x7e, 0x7d, 0x7f:
-			result = append(result, 0x7d, 0x20^character)
-		default:
-			result = append(result, character)
-		}
+// Close closes the connection to CellAdvisor
+func (cl *CellAdvisor) Close() error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.close()
+}
+
+func (cl *CellAdvisor) close() error {
+	if cl.conn == nil {
+		return nil
 	}
-	return result
-}
-
-func unmaskingCommandCharacter(data []byte) ([]byte, byte) {
-	var result []byte
-	var masking bool
-	for _, character := range data {
-		switch character {
-		case 0x7d:
-			masking = true
-		default:
-			if masking {
-				masking = false
-				result = append(result, character^0x20)
-			} else {
-				result = append(result, character)
-			}
-		}
+	err := cl.conn.Close()
+	if cl.demux != nil {
+		<-cl.demux.done
+		cl.demux = nil
 	}
-	return result[:len(result)-1], result[len(result)-1]
+	return err
 }
 
-func getChecksum(data []byte) byte {
-	total := 0
-	for _, value := range data {
-		total += int(value)
+func (cl *CellAdvisor) initCellAdvisor(ctx context.Context) error {
+
+	conn, err := cl.options.dial(ctx, cl.ip)
+	if err != nil {
+		return err
+	}
+
+	cl.conn = conn
+	cl.decoder = NewDecoder(conn)
+	cl.info = nil
+	timeout := cl.options.IdentifyTimeout
+	if timeout <= 0 {
+		timeout = DefaultIdentifyTimeout
+	}
+	identifyCtx, cancel := context.WithTimeout(ctx, timeout)
+	err = cl.identify(identifyCtx)
+	cancel()
+	if IsConnectionError(err) {
+		// a late answer would be taken for the reply to the next request
+		conn.Close()
+		return fmt.Errorf("cell: *IDN? on connect: %w", err)
 	}
-	return byte(total & 0xff)
+	if err != nil {
+		log.Println("identification on connect failed: ", err.Error())
+	}
+	if cl.demuxEnabled {
+		cl.demux = startDemuxer(cl)
+	}
+	return nil
+}
+
+// GetScreen returning current devices jpeg screenshot
+func (cl *CellAdvisor) GetScreen() ([]byte, error) {
+	return cl.GetScreenContext(context.Background())
+}
+
+// GetScreenContext is like GetScreen, but gives up once ctx is done
+func (cl *CellAdvisor) GetScreenContext(ctx context.Context) ([]byte, error) {
+	return cl.TransactContext(ctx, 0x60, "")
+}
+
+// GetStatusMessage returning a heartbeat signal message from CellAdvisor
+func (cl *CellAdvisor) GetStatusMessage() (string, error) {
+	return cl.GetStatusMessageContext(context.Background())
+}
+
+// GetStatusMessageContext is like GetStatusMessage, but gives up once ctx is done
+func (cl *CellAdvisor) GetStatusMessageContext(ctx context.Context) (string, error) {
+	ret, err := cl.TransactContext(ctx, 0x50, "")
+	return string(ret), err
 }
 
 // NewCellAdvisor creates new CellAdvior object with given ip address
-func NewCellAdvisor(ip string) CellAdvisor {
-	cell := CellAdvisor{ip: ip}
-	cell.initCellAdvisor()
-	return cell
+// it returns a *DialError if the device could not be reached
+func NewCellAdvisor(ip string) (*CellAdvisor, error) {
+	return NewCellAdvisorWithOptions(ip, DialOptions{})
+}
+
+// NewCellAdvisorWithOptions creates new CellAdvisor object with given ip address
+// connecting as described by opts, Reinitialize reuses the same options.
+// Errors are those of Reinitialize
+func NewCellAdvisorWithOptions(ip string, opts DialOptions) (*CellAdvisor, error) {
+	return NewCellAdvisorContext(context.Background(), ip, opts)
+}
+
+// NewCellAdvisorContext is like NewCellAdvisorWithOptions, but gives up
+// connecting and identifying the device once ctx is done
+func NewCellAdvisorContext(ctx context.Context, ip string, opts DialOptions) (*CellAdvisor, error) {
+	cell := &CellAdvisor{
+		ip:              ip,
+		options:         opts,
+		maxPayloadSize:  DefaultMaxPayloadSize,
+		opcPollInterval: DefaultOPCPollInterval,
+		opcTimeout:      DefaultOPCTimeout,
+	}
+	if err := cell.initCellAdvisor(ctx); err != nil {
+		return nil, err
+	}
+	return cell, nil
 }
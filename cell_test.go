@@ -3,10 +3,14 @@ package cell
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net"
+	"sync"
 	"testing"
 	"time"
 )
@@ -24,15 +28,14 @@ func randInt(min int, max int) int {
 	return min + rand.Intn(max-min)
 }
 
-func buildFakeCellAdvisorTCPConnection() <-chan int {
-	done := make(chan int)
+// buildFakeCellAdvisorTCPConnection starts an echo server on a random
+// loopback port and returns the DialOptions to reach it
+func buildFakeCellAdvisorTCPConnection() DialOptions {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
 	go func() {
-
-		l, err := net.Listen("tcp", JDProtocolPort)
-		if err != nil {
-			log.Fatal(err)
-		}
-		close(done)
 		defer l.Close()
 		for {
 			// Wait for a connection.
@@ -40,27 +43,34 @@ func buildFakeCellAdvisorTCPConnection() <-chan int {
 			if err != nil {
 				log.Fatal(err)
 			}
-			go func(c net.Conn) {
-				reader := bufio.NewReader(c)
-				for {
-					buf, err := reader.ReadBytes(0x7e)
-					if err != nil {
-						fmt.Println("Error reading:", err.Error())
-					}
-					c.Write(buf)
-				}
-				c.Close()
-			}(conn)
+			go echoFrames(conn)
 		}
 	}()
-	return done
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return DialOptions{Port: ":" + port}
+}
+
+func echoFrames(c net.Conn) {
+	defer c.Close()
+	reader := bufio.NewReader(c)
+	for {
+		buf, err := reader.ReadBytes(0x7e)
+		if err != nil {
+			if err != io.EOF {
+				fmt.Println("Error reading:", err.Error())
+			}
+			return
+		}
+		c.Write(buf)
+	}
 }
+
 func TestSendMessageAndGetMessageSync(t *testing.T) {
 	var compare []byte
-	done := buildFakeCellAdvisorTCPConnection()
-	JDProtocolPort = ":18081"
-	<-done
-	cl := NewCellAdvisor("")
+	cl, err := NewCellAdvisorWithOptions("127.0.0.1", buildFakeCellAdvisorTCPConnection())
+	if err != nil {
+		t.Fatal(err)
+	}
 	for i := 0; i < 200; i++ {
 
 		result := randomBytes(10)
@@ -81,3 +91,275 @@ func TestSendMessageAndGetMessageSync(t *testing.T) {
 		}
 	}
 }
+
+func TestDialContextPipe(t *testing.T) {
+	client, device := net.Pipe()
+	go echoFrames(device)
+	var dialed string
+	cl, err := NewCellAdvisorWithOptions("pipe", DialOptions{
+		Port: ":1",
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed = address
+			return client, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if dialed != "pipe:1" {
+		t.Fatalf("dialed = %s, want pipe:1", dialed)
+	}
+	status, err := cl.GetStatusMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "" {
+		t.Fatalf("status = %q, want empty echo", status)
+	}
+}
+
+func TestDialContextTimeout(t *testing.T) {
+	_, err := NewCellAdvisorWithOptions("pipe", DialOptions{
+		Timeout: 50 * time.Millisecond,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	if !errors.Is(err, ErrDialTimeout) {
+		t.Fatalf("error = %v, want %v", err, ErrDialTimeout)
+	}
+}
+
+func TestNewCellAdvisorContextIdentification(t *testing.T) {
+	client, device := net.Pipe()
+	go io.Copy(io.Discard, device)
+	defer device.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewCellAdvisorContext(ctx, "pipe", DialOptions{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return client, nil
+		},
+	})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("error = %v, want %v", err, ErrTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("gave up after %v, want ctx deadline", elapsed)
+	}
+}
+
+func TestNewCellAdvisorDNSTemporary(t *testing.T) {
+	_, err := NewCellAdvisorWithOptions("celladvisor", DialOptions{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, &net.DNSError{Err: "server misbehaving", Name: "celladvisor", IsTemporary: true}
+		},
+	})
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || dialErr.Kind != nil {
+		t.Fatalf("error = %v, want *DialError of no kind", err)
+	}
+}
+
+func TestNewCellAdvisorRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	cl, err := NewCellAdvisorWithOptions("127.0.0.1", DialOptions{Port: ":" + port, Timeout: time.Second})
+	if cl != nil {
+		t.Fatal("NewCellAdvisor returned a CellAdvisor for a refused connection")
+	}
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) {
+		t.Fatalf("error = %v, want *DialError", err)
+	}
+	if !errors.Is(err, ErrConnectionRefused) {
+		t.Fatalf("error kind = %v, want %v", dialErr.Kind, ErrConnectionRefused)
+	}
+}
+
+func TestNewCellAdvisorDialErrorKind(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		kind error
+	}{
+		{context.DeadlineExceeded, ErrDialTimeout},
+		{&net.DNSError{Err: "no such host", Name: "celladvisor", IsNotFound: true}, ErrHostNotFound},
+		{&net.DNSError{Err: "i/o timeout", Name: "celladvisor", IsTimeout: true}, ErrDialTimeout},
+	} {
+		cl, err := NewCellAdvisorWithOptions("celladvisor", DialOptions{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, tc.err
+			},
+		})
+		if cl != nil {
+			t.Fatal("NewCellAdvisor returned a CellAdvisor for a failed dial")
+		}
+		if !errors.Is(err, tc.kind) {
+			t.Fatalf("error = %v, want kind %v", err, tc.kind)
+		}
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("error = %v, want it to wrap %v", err, tc.err)
+		}
+	}
+}
+
+// pipeIDN is the *IDN? reply of devices answering identification
+const pipeIDN = "JDSU,JD745A,000000001,1.00.000\n"
+
+// answerIdentification answers the *IDN? query made on connect with pipeIDN
+func answerIdentification(device net.Conn) error {
+	f, err := NewDecoder(device).Decode()
+	if err != nil {
+		return err
+	}
+	if f.Command != 0x61 || string(f.Payload) != "*IDN?\n" {
+		return fmt.Errorf("got %x %q, want *IDN? on connect", f.Command, f.Payload)
+	}
+	return NewEncoder(device).Encode(NewFrame(0x61, []byte(pipeIDN)))
+}
+
+// pipeCellAdvisor returns a CellAdvisor connected over an in-memory pipe
+// to a device run by serve once it answered identification
+func pipeCellAdvisor(t *testing.T, serve func(device net.Conn)) *CellAdvisor {
+	client, device := net.Pipe()
+	go func() {
+		if err := answerIdentification(device); err != nil {
+			device.Close()
+			return
+		}
+		serve(device)
+	}()
+	cl, err := NewCellAdvisorWithOptions("pipe", DialOptions{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return client, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cl
+}
+
+// silentCellAdvisor returns a CellAdvisor whose device reads requests but never answers
+func silentCellAdvisor(t *testing.T) *CellAdvisor {
+	return pipeCellAdvisor(t, func(device net.Conn) {
+		io.Copy(io.Discard, device)
+	})
+}
+
+func TestGetMessageContextTimeout(t *testing.T) {
+	cl := silentCellAdvisor(t)
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cl.GetScreenContext(ctx); err != ErrTimeout {
+		t.Fatalf("error = %v, want %v", err, ErrTimeout)
+	}
+}
+
+func TestGetMessageContextCancel(t *testing.T) {
+	cl := silentCellAdvisor(t)
+	defer cl.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := cl.GetStatusMessageContext(ctx); err != context.Canceled {
+		t.Fatalf("error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestTransactConcurrent(t *testing.T) {
+	cl, err := NewCellAdvisorWithOptions("127.0.0.1", buildFakeCellAdvisorTCPConnection())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				payload := fmt.Sprintf("goroutine %d request %d", g, i)
+				reply, err := cl.Transact(0x61, payload)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if string(reply) != payload {
+					errs <- fmt.Errorf("reply = %q, want %q", reply, payload)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestSendMessageFragmented(t *testing.T) {
+	cl, err := NewCellAdvisorWithOptions("127.0.0.1", buildFakeCellAdvisorTCPConnection())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	for _, size := range []int{0, 1, DefaultMaxPayloadSize, DefaultMaxPayloadSize + 1, 10*DefaultMaxPayloadSize + 7} {
+		payload := randomBytes(size)
+		reply, err := cl.Transact(0x61, string(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(reply, payload) {
+			t.Fatalf("size %d: reassembled %d bytes, not same", size, len(reply))
+		}
+	}
+	cl.SetMaxPayloadSize(1)
+	if _, err := cl.SendMessage(0x61, string(randomBytes(256))); err != ErrPayloadTooLarge {
+		t.Fatalf("error = %v, want %v", err, ErrPayloadTooLarge)
+	}
+}
+
+func BenchmarkTransact(b *testing.B) {
+	cl, err := NewCellAdvisorWithOptions("127.0.0.1", buildFakeCellAdvisorTCPConnection())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cl.Close()
+	payload := string(randomBytes(64))
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := cl.Transact(0x61, payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkTransactPipelined(b *testing.B) {
+	cl, err := NewCellAdvisorWithOptions("127.0.0.1", buildFakeCellAdvisorTCPConnection())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cl.Close()
+	cl.SetDemux(true)
+	payload := string(randomBytes(64))
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := cl.Transact(0x61, payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package celltest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tomahawk28/cell"
+)
+
+// FaultKind names a failure the Server can inject
+type FaultKind string
+
+// Fault kinds
+const (
+	// FaultLatency delays the reply by Duration
+	FaultLatency FaultKind = "latency"
+	// FaultDisconnect closes the connection instead of replying
+	FaultDisconnect FaultKind = "disconnect"
+	// FaultTruncate sends the first half of the reply, then disconnects
+	FaultTruncate FaultKind = "truncate"
+	// FaultBadChecksum corrupts the checksum of every reply frame
+	FaultBadChecksum FaultKind = "bad_checksum"
+	// FaultGarbage sends bytes outside any frame before the reply
+	FaultGarbage FaultKind = "garbage"
+	// FaultRefuse drops every connection and refuses new ones for Duration,
+	// or until the faults are cleared if Duration is zero
+	FaultRefuse FaultKind = "refuse"
+)
+
+// Duration is a time.Duration read from JSON as "250ms" or nanoseconds
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	if s, err := strconv.Unquote(string(data)); err == nil {
+		parsed, err := time.ParseDuration(s)
+		*d = Duration(parsed)
+		return err
+	}
+	var ns int64
+	err := json.Unmarshal(data, &ns)
+	*d = Duration(ns)
+	return err
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Fault describes a failure injected on received commands.
+// Of the commands matching Command, the first After are left alone,
+// then each of the next Count ones fails with Probability
+type Fault struct {
+	Kind FaultKind `json:"kind"`
+	// Command is the command byte the fault applies to, any if zero
+	Command byte `json:"command,omitempty"`
+	// After is the number of matching commands to let through first
+	After int `json:"after,omitempty"`
+	// Count limits how many commands the fault applies to, no limit if zero
+	Count int `json:"count,omitempty"`
+	// Probability of failing a command, always if zero
+	Probability float64 `json:"probability,omitempty"`
+	// Duration is the latency or refusal period
+	Duration Duration `json:"duration,omitempty"`
+	// Garbage is sent by FaultGarbage, a few non frame bytes if empty
+	Garbage []byte `json:"garbage,omitempty"`
+}
+
+// Scenario is a set of faults, as read from a scenario file
+type Scenario struct {
+	// Seed makes probabilistic faults repeatable
+	Seed   int64   `json:"seed"`
+	Faults []Fault `json:"faults"`
+}
+
+// LoadScenario reads a JSON scenario file such as
+//
+//	{"seed": 1, "faults": [
+//		{"kind": "latency", "duration": "200ms"},
+//		{"kind": "disconnect", "command": 80, "after": 10, "count": 1}
+//	]}
+func LoadScenario(path string) (Scenario, error) {
+	var scenario Scenario
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenario, err
+	}
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return scenario, fmt.Errorf("celltest: %s: %w", path, err)
+	}
+	for _, f := range scenario.Faults {
+		switch f.Kind {
+		case FaultLatency, FaultDisconnect, FaultTruncate, FaultBadChecksum, FaultGarbage, FaultRefuse:
+		default:
+			return scenario, fmt.Errorf("celltest: %s: unknown fault kind %q", path, f.Kind)
+		}
+	}
+	return scenario, nil
+}
+
+// defaultGarbage holds stray bytes, a lone end byte among them,
+// but no start byte a decoder would take for a frame
+var defaultGarbage = []byte{0x00, 0xff, cell.FrameEnd, 'C', 0x20, cell.FrameEscape, 0x55}
+
+// faultState tracks how often a fault matched
+type faultState struct {
+	Fault
+	matched int
+}
+
+// SetScenario replaces injected faults with those of scenario
+func (s *Server) SetScenario(scenario Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+	for _, f := range scenario.Faults {
+		s.faults = append(s.faults, &faultState{Fault: f})
+	}
+	s.rand = rand.New(rand.NewSource(scenario.Seed))
+}
+
+// AddFault injects f on top of current faults
+func (s *Server) AddFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &faultState{Fault: f})
+}
+
+// ClearFaults stops injecting faults and accepts connections again
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	s.faults = nil
+	s.mu.Unlock()
+	s.accept()
+}
+
+// triggered returns the faults applying to the command just received
+func (s *Server) triggered(cmd byte) []Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var faults []Fault
+	for _, f := range s.faults {
+		if f.Command != 0 && f.Command != cmd {
+			continue
+		}
+		f.matched++
+		if f.matched <= f.After || f.Count > 0 && f.matched > f.After+f.Count {
+			continue
+		}
+		if f.Probability > 0 && s.rand.Float64() >= f.Probability {
+			continue
+		}
+		faults = append(faults, f.Fault)
+	}
+	return faults
+}
+
+// Refuse closes every connection and refuses new ones for d,
+// or until the faults are cleared if d is zero
+func (s *Server) Refuse(d time.Duration) {
+	s.mu.Lock()
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	s.mu.Unlock()
+	s.CloseClientConnections()
+	if d > 0 {
+		time.AfterFunc(d, s.accept)
+	}
+}
+
+// accept listens again on the address of s, if it stopped refusing.
+// Failing to do so is logged and kept for Err
+func (s *Server) accept() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil || s.closed {
+		return
+	}
+	listener, err := listen(s.Addr)
+	if err != nil {
+		s.err = fmt.Errorf("celltest: failed to listen again on %s: %w", s.Addr, err)
+		log.Println(s.err.Error())
+		return
+	}
+	s.err = nil
+	s.listener = listener
+	s.wg.Add(1)
+	go s.serve(listener)
+}
@@ -0,0 +1,166 @@
+package celltest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tomahawk28/cell"
+)
+
+func faultyCellAdvisor(t *testing.T, faults ...Fault) (*Server, *cell.CellAdvisor) {
+	s := NewServer()
+	s.SetScenario(Scenario{Faults: faults})
+	cl, err := s.NewCellAdvisor()
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return s, cl
+}
+
+func TestFaultFrames(t *testing.T) {
+	s, cl := faultyCellAdvisor(t,
+		Fault{Kind: FaultGarbage, Command: 0x50, Count: 1},
+		Fault{Kind: FaultBadChecksum, Command: 0x50, After: 1, Count: 1},
+		Fault{Kind: FaultTruncate, Command: 0x50, After: 2},
+	)
+	defer s.Close()
+	defer cl.Close()
+	cl.SetChecksumPolicy(cell.ChecksumFail, 0)
+
+	if status, err := cl.GetStatusMessage(); err != nil || status != DefaultStatus {
+		t.Fatalf("status after garbage = %q, %v", status, err)
+	}
+	if _, err := cl.GetStatusMessage(); !errors.Is(err, cell.ErrChecksum) {
+		t.Fatalf("error = %v, want %v", err, cell.ErrChecksum)
+	}
+	if _, err := cl.GetStatusMessage(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestFaultLatencyAndDisconnect(t *testing.T) {
+	s, cl := faultyCellAdvisor(t,
+		Fault{Kind: FaultLatency, Command: 0x50, Duration: Duration(200 * time.Millisecond), Count: 1},
+		Fault{Kind: FaultDisconnect, Command: 0x60},
+	)
+	defer s.Close()
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := cl.GetStatusMessageContext(ctx); err != cell.ErrTimeout {
+		t.Fatalf("error = %v, want %v", err, cell.ErrTimeout)
+	}
+	// the late reply is still on its way, start over on a new connection
+	if err := cl.Reinitialize(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.GetScreen(); err != io.EOF {
+		t.Fatalf("error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestFaultRefuse(t *testing.T) {
+	s, cl := faultyCellAdvisor(t, Fault{Kind: FaultRefuse, Command: 0x50, Count: 1})
+	defer s.Close()
+	defer cl.Close()
+
+	if _, err := cl.GetStatusMessage(); err != io.EOF {
+		t.Fatalf("error = %v, want %v", err, io.EOF)
+	}
+	if err := cl.Reinitialize(); !errors.Is(err, cell.ErrConnectionRefused) {
+		t.Fatalf("Reinitialize error = %v, want %v", err, cell.ErrConnectionRefused)
+	}
+	s.ClearFaults()
+	if err := cl.Reinitialize(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.GetStatusMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Connections(); n != 2 {
+		t.Fatalf("accepted %d connections, want 2", n)
+	}
+}
+
+func TestRefuseFromStart(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Refuse(0)
+	if _, err := s.NewCellAdvisor(); !errors.Is(err, cell.ErrConnectionRefused) {
+		t.Fatalf("error = %v, want %v", err, cell.ErrConnectionRefused)
+	}
+	s.ClearFaults()
+	cl, err := s.NewCellAdvisor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.Close()
+
+	// a reconnecting CellAdvisor keeps dialing until the refusal ends
+	s.Refuse(50 * time.Millisecond)
+	r := cell.NewReconnectingCellAdvisor(s.IP, cell.ReconnectOptions{Dial: s.DialOptions(), MinBackoff: 10 * time.Millisecond})
+	defer r.Close()
+	if status, err := r.GetStatusMessage(); err != nil || status != DefaultStatus {
+		t.Fatalf("GetStatusMessage = %q, %v", status, err)
+	}
+	if n := s.Connections(); n != 2 {
+		t.Fatalf("accepted %d connections, want 2", n)
+	}
+}
+
+func TestFaultRefusePortTaken(t *testing.T) {
+	s, cl := faultyCellAdvisor(t, Fault{Kind: FaultRefuse, Command: 0x50, Count: 1})
+	defer s.Close()
+	defer cl.Close()
+
+	if _, err := cl.GetStatusMessage(); err != io.EOF {
+		t.Fatalf("error = %v, want %v", err, io.EOF)
+	}
+	taken, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ClearFaults()
+	if err := s.Err(); err == nil {
+		t.Fatal("Err = nil while the port is taken")
+	}
+	taken.Close()
+	s.ClearFaults()
+	if err := s.Err(); err != nil {
+		t.Fatalf("Err = %v once the port is free", err)
+	}
+	if err := cl.Reinitialize(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	os.WriteFile(path, []byte(`{"seed": 7, "faults": [
+		{"kind": "latency", "duration": "150ms"},
+		{"kind": "disconnect", "command": 80, "after": 10, "count": 1, "probability": 0.5}
+	]}`), 0o644)
+	scenario, err := LoadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scenario.Seed != 7 || len(scenario.Faults) != 2 || time.Duration(scenario.Faults[0].Duration) != 150*time.Millisecond {
+		t.Fatalf("scenario = %+v", scenario)
+	}
+	if f := scenario.Faults[1]; f.Kind != FaultDisconnect || f.Command != 0x50 || f.After != 10 || f.Count != 1 || f.Probability != 0.5 {
+		t.Fatalf("fault = %+v", f)
+	}
+
+	os.WriteFile(path, []byte(`{"faults": [{"kind": "meteor"}]}`), 0o644)
+	if _, err := LoadScenario(path); err == nil {
+		t.Fatal("LoadScenario accepted an unknown fault kind")
+	}
+}
@@ -0,0 +1,559 @@
+package celltest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomahawk28/cell"
+)
+
+// Frequency range accepted by Instrument
+const (
+	MinFrequency = 9 * cell.KHz
+	MaxFrequency = 6 * cell.GHz
+)
+
+// DefaultModes is the mode catalogue of a new Instrument
+var DefaultModes = []string{
+	"SPECTRUM", "SPECTRUM/CHANNEL POWER", "SPECTRUM/OCCUPIED BW",
+	"INTERFERENCE", "INTERFERENCE/SPECTROGRAM", "INTERFERENCE/RSSI",
+	"SIGNAL/LTE",
+}
+
+// maxErrorQueue bounds the SCPI error queue, the last entry
+// then reports the overflow
+const maxErrorQueue = 16
+
+// Signal represents a carrier or an interferer in the synthetic spectrum
+type Signal struct {
+	// Frequency is the center of the signal in Hz
+	Frequency float64
+	// Bandwidth is the occupied bandwidth in Hz, zero for a CW tone
+	Bandwidth float64
+	// Power is the level inside the occupied bandwidth in dBm
+	Power float64
+}
+
+// Instrument is a stateful SCPI model of a spectrum analyzer.
+// Settings changed by SCPI commands are returned by queries and shape
+// the synthetic spectrum, a noise floor plus carriers and interferers,
+// which trace queries, 0x83 and 0x50 reflect once given to Server.SetInstrument
+type Instrument struct {
+	mu sync.Mutex
+
+	center, span float64
+	rbw, vbw     float64
+	refLevel     float64
+	attenuation  float64
+	detector     string
+	averageCount int
+	averaging    bool
+	traceModes   map[int]string
+	mode         string
+	modes        []string
+	realFormat   bool
+	littleEndian bool
+	errors       []string
+	noiseDensity float64
+	points       int
+	carriers     []Signal
+	interferers  []Signal
+}
+
+// NewInstrument returning an Instrument in its preset state
+func NewInstrument() *Instrument {
+	in := &Instrument{modes: append([]string(nil), DefaultModes...), noiseDensity: -150, points: 601}
+	in.preset()
+	return in
+}
+
+// preset restores measurement settings like *RST, signals are kept
+func (in *Instrument) preset() {
+	in.center, in.span = 1.85*cell.GHz, 100*cell.MHz
+	in.rbw, in.vbw = 30*cell.KHz, 30*cell.KHz
+	in.refLevel, in.attenuation = 0, 10
+	in.detector = string(cell.DetectorNormal)
+	in.averageCount, in.averaging = 10, false
+	in.traceModes = map[int]string{1: string(cell.TraceClearWrite)}
+	in.mode = in.modes[0]
+	in.realFormat, in.littleEndian = false, false
+}
+
+// AddCarrier adds a carrier to the synthetic spectrum
+func (in *Instrument) AddCarrier(s Signal) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.carriers = append(in.carriers, s)
+}
+
+// AddInterferer adds an interferer to the synthetic spectrum
+func (in *Instrument) AddInterferer(s Signal) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.interferers = append(in.interferers, s)
+}
+
+// SetNoiseDensity sets the noise floor in dBm/Hz, scaled by RBW on display
+func (in *Instrument) SetNoiseDensity(dbmPerHz float64) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.noiseDensity = dbmPerHz
+}
+
+// SetPoints sets the number of points of every sweep
+func (in *Instrument) SetPoints(points int) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.points = points
+}
+
+// Mode returning the active mode catalogue entry
+func (in *Instrument) Mode() string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.mode
+}
+
+// Trace returning a sweep of the synthetic spectrum with current settings
+func (in *Instrument) Trace() cell.Trace {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.sweep()
+}
+
+func (in *Instrument) sweep() cell.Trace {
+	trace := cell.Trace{
+		Unit:            "dBm",
+		StartFrequency:  in.center - in.span/2,
+		StopFrequency:   in.center + in.span/2,
+		CenterFrequency: in.center,
+		Span:            in.span,
+		RBW:             in.rbw,
+		VBW:             in.vbw,
+		Detector:        cell.Detector(in.detector),
+		Timestamp:       time.Now(),
+		Powertrace:      make([]float32, in.points),
+	}
+	floor := in.noiseDensity + 10*math.Log10(in.rbw)
+	signals := append(append([]Signal(nil), in.carriers...), in.interferers...)
+	for i, f := range trace.Frequencies() {
+		// sum in mW, so overlapping signals add up like on a real display
+		mw := math.Pow(10, (floor+noiseRipple(i))/10)
+		for _, s := range signals {
+			mw += math.Pow(10, in.signalLevel(s, f)/10)
+		}
+		trace.Powertrace[i] = float32(10 * math.Log10(mw))
+	}
+	return trace
+}
+
+// signalLevel returning the level of s seen at f through the RBW filter
+func (in *Instrument) signalLevel(s Signal, f float64) float64 {
+	offset := math.Abs(f-s.Frequency) - s.Bandwidth/2
+	if offset <= 0 {
+		return s.Power
+	}
+	// roughly gaussian RBW skirt, 3 dB down half an RBW away
+	return s.Power - 12*(offset/in.rbw)*(offset/in.rbw)
+}
+
+// noiseRipple returning a repeatable ±0.5 dB variation of point i
+func noiseRipple(i int) float64 {
+	x := uint32(i)*2654435761 + 0x9e3779b9
+	x ^= x >> 15
+	return float64(x%1000)/1000 - 0.5
+}
+
+// Status returning the 0x50 status payload of the instrument
+func (in *Instrument) Status() string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return fmt.Sprintf(`<Status Mode="%s" State="RUN"/>`, in.mode)
+}
+
+// InterferenceXML returning the 0x83 payload of a sweep
+func (in *Instrument) InterferenceXML() string {
+	in.mu.Lock()
+	trace := in.sweep()
+	in.mu.Unlock()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0"?>`+"\n"+`<Interference Unit="%s" StartFreq="%.0f" StopFreq="%.0f" RBW="%.0f" Time="%s"`,
+		trace.Unit, trace.StartFrequency, trace.StopFrequency, trace.RBW, trace.Timestamp.UTC().Format(time.RFC3339))
+	for i, p := range trace.Powertrace {
+		fmt.Fprintf(&buf, ` P%d="%.2f"`, i, p)
+	}
+	buf.WriteString("/>")
+	return buf.String()
+}
+
+// HandleSCPI answers SCPI commands as an SCPIHandler,
+// ';' separated commands are run in order and their answers joined.
+// Rejected commands are reported through SYST:ERR? and get no answer
+func (in *Instrument) HandleSCPI(scpicmd string) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	var answers []string
+	for _, command := range strings.Split(scpicmd, ";") {
+		if command = strings.TrimSpace(command); command == "" {
+			continue
+		}
+		answer, err := in.execute(command)
+		if err != nil {
+			in.pushError(err.Error())
+			continue
+		}
+		if answer != "" {
+			answers = append(answers, answer)
+		}
+	}
+	if len(answers) == 0 {
+		return ""
+	}
+	return strings.Join(answers, ";") + "\n"
+}
+
+func (in *Instrument) pushError(entry string) {
+	if len(in.errors) >= maxErrorQueue {
+		in.errors[maxErrorQueue-1] = `-350,"Queue overflow"`
+		return
+	}
+	in.errors = append(in.errors, entry)
+}
+
+// scpiError represents a SCPI error queue entry
+type scpiError struct {
+	code    int
+	message string
+}
+
+func (e scpiError) Error() string {
+	return fmt.Sprintf("%d,%q", e.code, e.message)
+}
+
+var (
+	errUndefinedHeader  = scpiError{-113, "Undefined header"}
+	errDataType         = scpiError{-104, "Data type error"}
+	errIllegalValue     = scpiError{-224, "Illegal parameter value"}
+	errOutOfRange       = scpiError{-222, "Data out of range"}
+	errSettingsConflict = scpiError{-221, "Settings conflict"}
+)
+
+// normalizeHeader upper-cases header and strips the leading colon
+// and the optional SENS node
+func normalizeHeader(header string) string {
+	header = strings.TrimPrefix(strings.ToUpper(header), ":")
+	return strings.TrimPrefix(header, "SENS:")
+}
+
+func (in *Instrument) execute(command string) (string, error) {
+	header, arg, _ := strings.Cut(command, " ")
+	header, arg = normalizeHeader(header), strings.TrimSpace(arg)
+	query := strings.HasSuffix(header, "?")
+	header = strings.TrimSuffix(header, "?")
+
+	switch {
+	case header == "*IDN" && query:
+		return DefaultIDN, nil
+	case header == "*OPC" && query:
+		return "1", nil
+	case header == "*RST":
+		in.preset()
+		return "", nil
+	case header == "*CLS":
+		in.errors = nil
+		return "", nil
+	case header == "*WAI" || header == "*OPC":
+		return "", nil
+	case header == "SYST:ERR" && query:
+		if len(in.errors) == 0 {
+			return `0,"No error"`, nil
+		}
+		entry := in.errors[0]
+		in.errors = in.errors[1:]
+		return entry, nil
+	case strings.HasPrefix(header, "KEYP"):
+		// front panel keys and touches change nothing in the model
+		return "", nil
+	case header == "FREQ:CENT":
+		return in.frequency(query, arg, &in.center, func(hz float64) error {
+			return in.setPlan(hz, in.span)
+		})
+	case header == "FREQ:SPAN":
+		return in.frequency(query, arg, &in.span, func(hz float64) error {
+			return in.setPlan(in.center, hz)
+		})
+	case header == "FREQ:STAR":
+		start := in.center - in.span/2
+		return in.frequency(query, arg, &start, func(hz float64) error {
+			stop := in.center + in.span/2
+			if hz >= stop {
+				// like the front panel, stop follows a start set beyond it
+				stop = hz + in.span
+			}
+			return in.setPlan((hz+stop)/2, stop-hz)
+		})
+	case header == "FREQ:STOP":
+		stop := in.center + in.span/2
+		return in.frequency(query, arg, &stop, func(hz float64) error {
+			start := in.center - in.span/2
+			if hz <= start {
+				start = hz - in.span
+			}
+			return in.setPlan((start+hz)/2, hz-start)
+		})
+	case header == "BAND:RES" || header == "BAND":
+		return in.frequency(query, arg, &in.rbw, in.bandwidthSetter(&in.rbw))
+	case header == "BAND:VID":
+		return in.frequency(query, arg, &in.vbw, in.bandwidthSetter(&in.vbw))
+	case header == "DISP:WIND:TRAC:Y:RLEV":
+		return in.level(query, arg, &in.refLevel, -120, 30)
+	case header == "INP:ATT":
+		return in.level(query, arg, &in.attenuation, 0, 55)
+	case header == "DET:FUNC":
+		return in.choice(query, arg, &in.detector, "NORM", "POS", "NEG", "SAMP", "RMS", "AVER")
+	case header == "AVER:COUN":
+		if query {
+			return strconv.Itoa(in.averageCount), nil
+		}
+		count, err := strconv.Atoi(arg)
+		if err != nil {
+			return "", errDataType
+		}
+		if count < 1 || count > 100 {
+			return "", errOutOfRange
+		}
+		in.averageCount = count
+		return "", nil
+	case header == "AVER:STAT":
+		if query {
+			return formatBool(in.averaging), nil
+		}
+		enabled, err := parseBool(arg)
+		if err != nil {
+			return "", err
+		}
+		in.averaging = enabled
+		return "", nil
+	case strings.HasPrefix(header, "TRAC") && strings.HasSuffix(header, ":MODE"):
+		number, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(header, "TRAC"), ":MODE"))
+		if err != nil || number < 1 || number > 6 {
+			return "", errUndefinedHeader
+		}
+		mode := in.traceModes[number]
+		if mode == "" {
+			mode = string(cell.TraceBlank)
+		}
+		if _, err := in.choice(query, arg, &mode, "WRIT", "MAXH", "MINH", "AVER", "VIEW", "BLAN"); err != nil {
+			return "", err
+		}
+		in.traceModes[number] = mode
+		if query {
+			return mode, nil
+		}
+		return "", nil
+	case header == "TRAC:DATA" && query:
+		return in.traceData(arg)
+	case header == "UNIT:POW" && query:
+		return "DBM", nil
+	case header == "FORM:DATA" || header == "FORM":
+		if query {
+			if in.realFormat {
+				return "REAL,32", nil
+			}
+			return "ASC,8", nil
+		}
+		switch strings.ReplaceAll(strings.ToUpper(arg), " ", "") {
+		case "REAL", "REAL,32":
+			in.realFormat = true
+		case "ASC", "ASCII", "ASC,8":
+			in.realFormat = false
+		default:
+			return "", errIllegalValue
+		}
+		return "", nil
+	case header == "FORM:BORD":
+		if query {
+			if in.littleEndian {
+				return "SWAP", nil
+			}
+			return "NORM", nil
+		}
+		switch strings.ToUpper(arg) {
+		case "SWAP":
+			in.littleEndian = true
+		case "NORM":
+			in.littleEndian = false
+		default:
+			return "", errIllegalValue
+		}
+		return "", nil
+	case header == "INST:CAT" && query:
+		quoted := make([]string, len(in.modes))
+		for i, mode := range in.modes {
+			quoted[i] = strconv.Quote(mode)
+		}
+		return strings.Join(quoted, ","), nil
+	case header == "INST:SEL" || header == "INST":
+		if query {
+			return strconv.Quote(in.mode), nil
+		}
+		mode := strings.Trim(arg, `"'`)
+		for _, known := range in.modes {
+			if strings.EqualFold(known, mode) {
+				in.mode = known
+				return "", nil
+			}
+		}
+		return "", errIllegalValue
+	}
+	return "", errUndefinedHeader
+}
+
+// frequency queries or sets a frequency value through set
+func (in *Instrument) frequency(query bool, arg string, value *float64, set func(hz float64) error) (string, error) {
+	if query {
+		return formatFloat(*value), nil
+	}
+	hz, err := parseValue(arg)
+	if err != nil {
+		return "", err
+	}
+	return "", set(hz)
+}
+
+// setPlan sets center and span, rejecting plans outside the frequency range
+func (in *Instrument) setPlan(center, span float64) error {
+	if span <= 0 {
+		return errSettingsConflict
+	}
+	if center-span/2 < MinFrequency || center+span/2 > MaxFrequency {
+		return errOutOfRange
+	}
+	in.center, in.span = center, span
+	return nil
+}
+
+func (in *Instrument) bandwidthSetter(target *float64) func(hz float64) error {
+	return func(hz float64) error {
+		if hz < 1*cell.Hz || hz > 10*cell.MHz {
+			return errOutOfRange
+		}
+		*target = hz
+		return nil
+	}
+}
+
+// level queries or sets a value in dB or dBm within min and max
+func (in *Instrument) level(query bool, arg string, value *float64, min, max float64) (string, error) {
+	if query {
+		return formatFloat(*value), nil
+	}
+	v, err := parseValue(arg)
+	if err != nil {
+		return "", err
+	}
+	if v < min || v > max {
+		return "", errOutOfRange
+	}
+	*value = v
+	return "", nil
+}
+
+// choice queries or sets one of the given SCPI short forms
+func (in *Instrument) choice(query bool, arg string, value *string, choices ...string) (string, error) {
+	if query {
+		return *value, nil
+	}
+	arg = strings.ToUpper(arg)
+	for _, c := range choices {
+		if strings.HasPrefix(arg, c) {
+			*value = c
+			return "", nil
+		}
+	}
+	return "", errIllegalValue
+}
+
+// traceData answers TRAC:DATA? in the current format
+func (in *Instrument) traceData(arg string) (string, error) {
+	number, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(arg), "TRACE"))
+	if err != nil || number < 1 || number > 6 {
+		return "", errIllegalValue
+	}
+	if mode := in.traceModes[number]; mode == "" || mode == string(cell.TraceBlank) {
+		return "", errSettingsConflict
+	}
+	trace := in.sweep()
+	if !in.realFormat {
+		values := make([]string, len(trace.Powertrace))
+		for i, p := range trace.Powertrace {
+			values[i] = strconv.FormatFloat(float64(p), 'f', 2, 32)
+		}
+		return strings.Join(values, ","), nil
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if in.littleEndian {
+		order = binary.LittleEndian
+	}
+	data := make([]byte, 4*len(trace.Powertrace))
+	for i, p := range trace.Powertrace {
+		order.PutUint32(data[i*4:], math.Float32bits(p))
+	}
+	length := strconv.Itoa(len(data))
+	return fmt.Sprintf("#%d%s%s", len(length), length, data), nil
+}
+
+// valueUnits maps SCPI unit suffixes to their multiplier, longest first
+var valueUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"GHZ", cell.GHz}, {"MHZ", cell.MHz}, {"KHZ", cell.KHz}, {"HZ", cell.Hz},
+	{"DBM", 1}, {"DB", 1},
+}
+
+// parseValue parses a numeric SCPI parameter, unit suffix optional
+func parseValue(arg string) (float64, error) {
+	arg = strings.ToUpper(strings.TrimSpace(arg))
+	multiplier := 1.0
+	for _, unit := range valueUnits {
+		if strings.HasSuffix(arg, unit.suffix) {
+			arg, multiplier = strings.TrimSpace(strings.TrimSuffix(arg, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, errDataType
+	}
+	return v * multiplier, nil
+}
+
+func parseBool(arg string) (bool, error) {
+	switch strings.ToUpper(arg) {
+	case "1", "ON":
+		return true, nil
+	case "0", "OFF":
+		return false, nil
+	}
+	return false, errIllegalValue
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// formatFloat formats like instruments do, e.g. +1.850000000E+09
+func formatFloat(v float64) string {
+	return fmt.Sprintf("%+.9E", v)
+}
@@ -0,0 +1,138 @@
+package celltest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tomahawk28/cell"
+)
+
+func instrumentCellAdvisor(t *testing.T) (*Instrument, *cell.CellAdvisor, func()) {
+	s := NewServer()
+	in := NewInstrument()
+	s.SetInstrument(in)
+	cl, err := s.NewCellAdvisor()
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return in, cl, func() {
+		cl.Close()
+		s.Close()
+	}
+}
+
+// peak returning the index of the highest point
+func peak(points []float32) int {
+	max := 0
+	for i, p := range points {
+		if p > points[max] {
+			max = i
+		}
+	}
+	return max
+}
+
+func TestInstrumentSettings(t *testing.T) {
+	_, cl, done := instrumentCellAdvisor(t)
+	defer done()
+
+	if err := cl.SetCenterFrequency(900 * cell.MHz); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.SetSpan(20 * cell.MHz); err != nil {
+		t.Fatal(err)
+	}
+	if start, stop, err := cl.StartStop(); err != nil || start != 890e6 || stop != 910e6 {
+		t.Fatalf("StartStop = %v, %v, %v", start, stop, err)
+	}
+	if err := cl.SetStartStop(2*cell.GHz, 2.1*cell.GHz); err != nil {
+		t.Fatal(err)
+	}
+	if hz, err := cl.CenterFrequency(); err != nil || hz != 2.05e9 {
+		t.Fatalf("CenterFrequency = %v, %v", hz, err)
+	}
+	if err := cl.SetRBW(100 * cell.KHz); err != nil {
+		t.Fatal(err)
+	}
+	if hz, err := cl.RBW(); err != nil || hz != 100e3 {
+		t.Fatalf("RBW = %v, %v", hz, err)
+	}
+	if err := cl.SetReferenceLevel(-20); err != nil {
+		t.Fatal(err)
+	}
+	if dbm, err := cl.ReferenceLevel(); err != nil || dbm != -20 {
+		t.Fatalf("ReferenceLevel = %v, %v", dbm, err)
+	}
+	if err := cl.SetAveraging(4); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := cl.Averaging(); err != nil || count != 4 {
+		t.Fatalf("Averaging = %v, %v", count, err)
+	}
+
+	if err := cl.SetMode("INTERFERENCE/SPECTROGRAM"); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := cl.GetStatus(); err != nil || status.Mode != "INTERFERENCE/SPECTROGRAM" {
+		t.Fatalf("GetStatus = %+v, %v", status, err)
+	}
+	if err := cl.SetMode("TELEPORTER"); err == nil {
+		t.Fatal("SetMode accepted a mode outside the catalogue")
+	}
+}
+
+func TestInstrumentErrorQueue(t *testing.T) {
+	_, cl, done := instrumentCellAdvisor(t)
+	defer done()
+
+	_, err := cl.SendSCPIChecked(":SENS:FREQ:CENT 7 GHZ")
+	var scpiErr *cell.SCPIError
+	if !errors.As(err, &scpiErr) || scpiErr.Code != -222 {
+		t.Fatalf("error = %v, want -222 out of range", err)
+	}
+	if _, err := cl.SendSCPIChecked(":BOGUS:CMD 1"); !errors.As(err, &scpiErr) || scpiErr.Code != -113 {
+		t.Fatalf("error = %v, want -113 undefined header", err)
+	}
+	if _, err := cl.SendSCPIChecked(":SENS:FREQ:CENT 1 GHZ"); err != nil {
+		t.Fatal(err)
+	}
+	if hz, err := cl.CenterFrequency(); err != nil || hz != 1e9 {
+		t.Fatalf("CenterFrequency = %v, %v", hz, err)
+	}
+}
+
+func TestInstrumentSpectrum(t *testing.T) {
+	in, cl, done := instrumentCellAdvisor(t)
+	defer done()
+	in.SetPoints(201)
+	in.AddCarrier(Signal{Frequency: 1.84 * cell.GHz, Bandwidth: 5 * cell.MHz, Power: -40})
+	in.AddInterferer(Signal{Frequency: 1.87 * cell.GHz, Power: -30})
+
+	trace, err := cl.GetTrace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Powertrace) != 201 || trace.Unit != "dBm" {
+		t.Fatalf("trace = %d points in %s", len(trace.Powertrace), trace.Unit)
+	}
+	frequencies := trace.Frequencies()
+	if f := frequencies[peak(trace.Powertrace)]; f != 1.87e9 {
+		t.Fatalf("peak at %v Hz, want the interferer at 1.87 GHz", f)
+	}
+	carrier := trace.Powertrace[int((1.84e9-trace.StartFrequency)/(trace.Span/200))]
+	if carrier < -41 || carrier > -39 {
+		t.Fatalf("carrier level = %v dBm, want -40", carrier)
+	}
+	if floor := trace.Powertrace[0]; floor > -100 {
+		t.Fatalf("noise floor = %v dBm", floor)
+	}
+
+	power, err := cl.GetInterferencePower()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(power.Powertrace) != 201 || power.Frequencies()[peak(power.Powertrace)] != 1.87e9 {
+		t.Fatalf("interference power does not show the interferer")
+	}
+}
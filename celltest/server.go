@@ -0,0 +1,423 @@
+// Package celltest provides a fake CellAdvisor device for tests,
+// speaking JD protocol framing on a loopback TCP port
+package celltest
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomahawk28/cell"
+)
+
+// Defaults answered by a new Server
+const (
+	DefaultStatus       = `<Status Mode="IA" State="RUN"/>`
+	DefaultIDN          = "JDSU,JD745A,000000000,1.00.000"
+	DefaultInterference = `<?xml version="1.0"?>
+<Interference Unit="dBm" StartFreq="1.8 GHz" StopFreq="1.9 GHz" RBW="30 kHz"
+ P0="-100.00" P1="-95.50" P2="-60.25" P3="-97.00" P4="-101.75"/>`
+)
+
+// Command represents a message received by the fake device
+type Command struct {
+	// Command is the command byte of the message
+	Command byte
+	// Payload is the reassembled message data
+	Payload []byte
+	// Time is when the last frame of the message arrived
+	Time time.Time
+}
+
+// SCPIHandler answers a SCPI command, without its trailing newline.
+// An empty answer sends nothing back, like an instrument does for settings
+type SCPIHandler func(scpicmd string) string
+
+// Server is a fake CellAdvisor listening on a random loopback port.
+// It answers 0x50 with a status payload, 0x60 with a JPEG screen,
+// 0x83 with interference XML and 0x61 through its SCPI handler,
+// failing as told by its faults
+type Server struct {
+	// Addr is the listening address in host:port form
+	Addr string
+	// IP and Port are where clients connect, Port in ":66" form like cell.DialOptions
+	IP, Port string
+
+	wg sync.WaitGroup
+
+	mu sync.Mutex
+	// listener is nil while connections are refused,
+	// err tells why if listening again failed
+	listener       net.Listener
+	err            error
+	closed         bool
+	conns          map[net.Conn]struct{}
+	accepted       int
+	faults         []*faultState
+	rand           *rand.Rand
+	commands       []Command
+	status         string
+	screen         []byte
+	interference   string
+	scpi           SCPIHandler
+	render         func() []byte
+	instrument     *Instrument
+	maxPayloadSize int
+}
+
+// NewServer starts a fake CellAdvisor, answering with the defaults
+// until told otherwise. It panics if no loopback port is available
+func NewServer() *Server {
+	s, err := NewServerAt("127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("celltest: failed to listen on a port: %v", err))
+	}
+	return s
+}
+
+// NewServerAt is like NewServer, but listens on addr in host:port form
+// and returns an error if it cannot
+func NewServerAt(addr string) (*Server, error) {
+	listener, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip, port, _ := net.SplitHostPort(listener.Addr().String())
+	if parsed := net.ParseIP(ip); parsed == nil || parsed.IsUnspecified() {
+		// listening on every address, clients connect through loopback
+		ip = "127.0.0.1"
+	}
+	s := &Server{
+		Addr:         listener.Addr().String(),
+		IP:           ip,
+		Port:         ":" + port,
+		listener:     listener,
+		conns:        map[net.Conn]struct{}{},
+		status:       DefaultStatus,
+		screen:       DefaultScreen(),
+		interference: DefaultInterference,
+		scpi:         DefaultSCPI,
+		rand:         rand.New(rand.NewSource(1)),
+	}
+	s.wg.Add(1)
+	go s.serve(listener)
+	return s, nil
+}
+
+func listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+// DialOptions returning options connecting a CellAdvisor to s
+func (s *Server) DialOptions() cell.DialOptions {
+	return cell.DialOptions{Port: s.Port, Timeout: time.Second}
+}
+
+// NewCellAdvisor returning a CellAdvisor connected to s
+func (s *Server) NewCellAdvisor() (*cell.CellAdvisor, error) {
+	return cell.NewCellAdvisorWithOptions(s.IP, s.DialOptions())
+}
+
+// SetStatus sets the 0x50 status payload
+func (s *Server) SetStatus(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// SetScreen sets the 0x60 JPEG screen capture
+func (s *Server) SetScreen(jpeg []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.screen = jpeg
+}
+
+// HandleScreen makes render answer 0x60 screen captures in place of
+// the screen set by SetScreen, nil restores it
+func (s *Server) HandleScreen(render func() []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.render = render
+}
+
+// SetInterference sets the 0x83 interference XML payload
+func (s *Server) SetInterference(xml string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interference = xml
+}
+
+// HandleSCPI sets the handler answering 0x61 SCPI commands
+func (s *Server) HandleSCPI(handler SCPIHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scpi = handler
+}
+
+// SetInstrument makes in answer SCPI commands, and its state and
+// synthetic spectrum answer 0x50 and 0x83. A nil in restores the fixed
+// payloads and DefaultSCPI
+func (s *Server) SetInstrument(in *Instrument) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instrument = in
+	if in == nil {
+		s.scpi = DefaultSCPI
+		return
+	}
+	s.scpi = in.HandleSCPI
+}
+
+// SetMaxPayloadSize splits replies over frames of at most size bytes,
+// zero or less sends every reply as a single frame
+func (s *Server) SetMaxPayloadSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxPayloadSize = size
+}
+
+// Commands returning every message received so far, in order
+func (s *Server) Commands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Command(nil), s.commands...)
+}
+
+// SCPICommands returning every SCPI command received so far,
+// without trailing newline
+func (s *Server) SCPICommands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var scpicmds []string
+	for _, c := range s.commands {
+		if c.Command == 0x61 {
+			scpicmds = append(scpicmds, strings.TrimRight(string(c.Payload), "\r\n"))
+		}
+	}
+	return scpicmds
+}
+
+// Connections returning the number of connections accepted so far
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// Err returning why s could not listen again after refusing connections,
+// nil if it did. Clearing faults tries again
+func (s *Server) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// CloseClientConnections closes every open connection, keeping s listening
+func (s *Server) CloseClientConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Close stops listening, closes every connection and waits for them to end
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	s.mu.Unlock()
+	s.CloseClientConnections()
+	s.wg.Wait()
+}
+
+func (s *Server) serve(listener net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.accepted++
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	dec := cell.NewDecoder(conn)
+	var payload []byte
+	for {
+		frame, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		payload = append(payload, frame.Payload...)
+		if !frame.Last() {
+			continue
+		}
+		reply, ok := s.answer(Command{Command: frame.Command, Payload: payload, Time: time.Now()})
+		payload = nil
+		if !s.reply(conn, frame.Command, reply, ok) {
+			return
+		}
+	}
+}
+
+// reply sends the reply to cmd, if any, through the faults it triggers.
+// It returns false once the connection is to be closed
+func (s *Server) reply(conn net.Conn, cmd byte, reply []byte, ok bool) bool {
+	var badChecksum, truncate bool
+	var garbage []byte
+	for _, f := range s.triggered(cmd) {
+		switch f.Kind {
+		case FaultLatency:
+			time.Sleep(time.Duration(f.Duration))
+		case FaultDisconnect:
+			return false
+		case FaultRefuse:
+			s.Refuse(time.Duration(f.Duration))
+			return false
+		case FaultBadChecksum:
+			badChecksum = true
+		case FaultTruncate:
+			truncate = true
+		case FaultGarbage:
+			if garbage = f.Garbage; len(garbage) == 0 {
+				garbage = defaultGarbage
+			}
+		}
+	}
+	if !ok {
+		return true
+	}
+	data, err := s.marshal(cmd, reply, badChecksum)
+	if err != nil {
+		return false
+	}
+	if truncate {
+		conn.Write(data[:len(data)/2])
+		return false
+	}
+	_, err = conn.Write(append(garbage, data...))
+	return err == nil
+}
+
+// answer records c and returns its reply, ok is false if none is sent
+func (s *Server) answer(c Command) (reply []byte, ok bool) {
+	s.mu.Lock()
+	s.commands = append(s.commands, c)
+	status, screen, interference, scpi, instrument := s.status, s.screen, s.interference, s.scpi, s.instrument
+	render := s.render
+	s.mu.Unlock()
+
+	switch c.Command {
+	case 0x50:
+		if instrument != nil {
+			return []byte(instrument.Status()), true
+		}
+		return []byte(status), true
+	case 0x60:
+		if render != nil {
+			return render(), true
+		}
+		return screen, true
+	case 0x83:
+		if instrument != nil {
+			return []byte(instrument.InterferenceXML()), true
+		}
+		return []byte(interference), true
+	case 0x61:
+		if scpi == nil {
+			return nil, false
+		}
+		ret := scpi(strings.TrimRight(string(c.Payload), "\r\n"))
+		return []byte(ret), ret != ""
+	}
+	return nil, false
+}
+
+// marshal encodes reply into frames, with wrong checksums if told so
+func (s *Server) marshal(cmd byte, reply []byte, badChecksum bool) ([]byte, error) {
+	s.mu.Lock()
+	maxPayloadSize := s.maxPayloadSize
+	s.mu.Unlock()
+	frames, err := cell.SplitFrames(cmd, reply, maxPayloadSize)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for _, frame := range frames {
+		if badChecksum {
+			frame.Checksum = frame.Sum() + 1
+		}
+		raw, err := frame.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, raw...)
+	}
+	return data, nil
+}
+
+// DefaultSCPI answers *IDN?, *OPC? and SYST:ERR? like an idle instrument
+// without errors, any other command gets no reply
+func DefaultSCPI(scpicmd string) string {
+	switch strings.ToUpper(scpicmd) {
+	case "*IDN?":
+		return DefaultIDN + "\n"
+	case "*OPC?":
+		return "1\n"
+	case "SYST:ERR?", ":SYST:ERR?":
+		return `0,"No error"` + "\n"
+	}
+	return ""
+}
+
+// jfifHeader is the APP0 segment image/jpeg leaves out,
+// screen captures of CellAdvisor carry it
+var jfifHeader = []byte{0xff, 0xe0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00}
+
+// EncodeScreen encodes img as a JFIF screen capture
+func EncodeScreen(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	// keep SOI first, then the JFIF segment
+	return append(append(append([]byte{}, data[:2]...), jfifHeader...), data[2:]...), nil
+}
+
+// DefaultScreen returning a blank 640x480 screen capture
+func DefaultScreen() []byte {
+	img := image.NewGray(image.Rect(0, 0, 640, 480))
+	for i := range img.Pix {
+		img.Pix[i] = color.Gray{Y: 0x20}.Y
+	}
+	data, err := EncodeScreen(img)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
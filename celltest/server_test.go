@@ -0,0 +1,80 @@
+package celltest
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.SetMaxPayloadSize(100)
+	cl, err := s.NewCellAdvisor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	screen, err := cl.GetScreen()
+	if err != nil || !bytes.Equal(screen, DefaultScreen()) || !bytes.Contains(screen, []byte("JFIF")) {
+		t.Fatalf("GetScreen = %d bytes, %v", len(screen), err)
+	}
+	if status, err := cl.GetStatus(); err != nil || status.Mode != "IA" {
+		t.Fatalf("GetStatus = %+v, %v", status, err)
+	}
+	if power, err := cl.GetInterferencePower(); err != nil || len(power.Powertrace) != 5 {
+		t.Fatalf("GetInterferencePower = %+v, %v", power, err)
+	}
+	if info, err := cl.GetDeviceInfo(); err != nil || info.Model != "JD745A" {
+		t.Fatalf("GetDeviceInfo = %+v, %v", info, err)
+	}
+
+	s.HandleSCPI(func(scpicmd string) string {
+		if scpicmd == ":SENS:FREQ:CENT?" {
+			return "1850000000\n"
+		}
+		return DefaultSCPI(scpicmd)
+	})
+	if hz, err := cl.CenterFrequency(); err != nil || hz != 1.85e9 {
+		t.Fatalf("CenterFrequency = %v, %v", hz, err)
+	}
+
+	commands := s.Commands()
+	// *IDN? is asked on connect, GetDeviceInfo answers from cache
+	want := []byte{0x61, 0x60, 0x50, 0x83, 0x61}
+	if len(commands) != len(want) {
+		t.Fatalf("recorded %d commands, want %d", len(commands), len(want))
+	}
+	for i, c := range commands {
+		if c.Command != want[i] {
+			t.Fatalf("command %d = %x, want %x", i, c.Command, want[i])
+		}
+	}
+	if scpicmds := s.SCPICommands(); scpicmds[0] != "*IDN?" || scpicmds[1] != ":SENS:FREQ:CENT?" {
+		t.Fatalf("SCPICommands = %q", scpicmds)
+	}
+}
+
+func TestServerAt(t *testing.T) {
+	s, err := NewServerAt(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var renders atomic.Int32
+	s.HandleScreen(func() []byte {
+		renders.Add(1)
+		return []byte("rendered")
+	})
+	cl, err := s.NewCellAdvisor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	for i := 1; i <= 2; i++ {
+		if screen, err := cl.GetScreen(); err != nil || string(screen) != "rendered" || renders.Load() != int32(i) {
+			t.Fatalf("GetScreen = %q, %v after %d renders", screen, err, renders.Load())
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package cell
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrChecksum matches any *ChecksumError with errors.Is
+var ErrChecksum = errors.New("cell: checksum mismatch")
+
+// ChecksumPolicy tells CellAdvisor what to do with replies
+// whose checksum does not match their content
+type ChecksumPolicy int
+
+const (
+	// ChecksumLog logs the mismatch and returns the data as is
+	ChecksumLog ChecksumPolicy = iota
+	// ChecksumFail returns a *ChecksumError instead of the data
+	ChecksumFail
+	// ChecksumRetry repeats the whole transaction, returning
+	// a *ChecksumError once retries are exhausted
+	ChecksumRetry
+)
+
+// ChecksumError represents a reply frame with a mismatching checksum
+type ChecksumError struct {
+	// Command is the command byte of the corrupted frame
+	Command byte
+	// Expected is the checksum computed from the frame content
+	Expected byte
+	// Actual is the checksum carried by the frame
+	Actual byte
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("cell: checksum mismatch on command 0x%02x, expected 0x%02x, got 0x%02x", e.Command, e.Expected, e.Actual)
+}
+
+// Is makes errors.Is(err, ErrChecksum) true
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChecksum
+}
+
+// SetChecksumPolicy sets how corrupted replies are handled,
+// retries is the number of repeated transactions under ChecksumRetry.
+// ChecksumLog is used until changed
+func (cl *CellAdvisor) SetChecksumPolicy(policy ChecksumPolicy, retries int) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.checksumPolicy, cl.checksumRetries = policy, retries
+}
+
+// ChecksumFailures returns the number of reply frames received
+// with a mismatching checksum, whatever the policy
+func (cl *CellAdvisor) ChecksumFailures() uint64 {
+	return cl.checksumFailures.Load()
+}
@@ -0,0 +1,76 @@
+package cell
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// corruptingCellAdvisor returns a CellAdvisor whose device echoes every
+// request, sending the first corrupted replies with a wrong checksum
+func corruptingCellAdvisor(t *testing.T, corrupted int) *CellAdvisor {
+	return pipeCellAdvisor(t, func(device net.Conn) {
+		defer device.Close()
+		dec, enc := NewDecoder(device), NewEncoder(device)
+		for {
+			f, err := dec.Decode()
+			if err != nil {
+				return
+			}
+			if corrupted > 0 {
+				corrupted--
+				f.Checksum++
+			}
+			if err := enc.Encode(f); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestChecksumLog(t *testing.T) {
+	cl := corruptingCellAdvisor(t, 1)
+	defer cl.Close()
+	if ret, err := cl.Transact(0x50, "ping"); err != nil || string(ret) != "ping" {
+		t.Fatalf("Transact = %q, %v", ret, err)
+	}
+	if n := cl.ChecksumFailures(); n != 1 {
+		t.Fatalf("ChecksumFailures = %d, want 1", n)
+	}
+}
+
+func TestChecksumFail(t *testing.T) {
+	cl := corruptingCellAdvisor(t, 1)
+	defer cl.Close()
+	cl.SetChecksumPolicy(ChecksumFail, 0)
+	_, err := cl.Transact(0x50, "ping")
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) || !errors.Is(err, ErrChecksum) {
+		t.Fatalf("error = %v, want *ChecksumError", err)
+	}
+	if checksumErr.Expected != checksumErr.Actual-1 || checksumErr.Command != 0x50 {
+		t.Fatalf("ChecksumError = %+v", checksumErr)
+	}
+	if ret, err := cl.Transact(0x50, "ping"); err != nil || string(ret) != "ping" {
+		t.Fatalf("Transact after failure = %q, %v", ret, err)
+	}
+}
+
+func TestChecksumRetry(t *testing.T) {
+	cl := corruptingCellAdvisor(t, 2)
+	defer cl.Close()
+	cl.SetChecksumPolicy(ChecksumRetry, 2)
+	if ret, err := cl.Transact(0x50, "ping"); err != nil || string(ret) != "ping" {
+		t.Fatalf("Transact = %q, %v", ret, err)
+	}
+	if n := cl.ChecksumFailures(); n != 2 {
+		t.Fatalf("ChecksumFailures = %d, want 2", n)
+	}
+
+	cl = corruptingCellAdvisor(t, 3)
+	defer cl.Close()
+	cl.SetChecksumPolicy(ChecksumRetry, 2)
+	if _, err := cl.Transact(0x50, "ping"); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("error = %v, want %v", err, ErrChecksum)
+	}
+}
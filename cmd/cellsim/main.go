@@ -0,0 +1,59 @@
+// Command cellsim runs a simulated CellAdvisor for developing without an
+// instrument. It speaks JD protocol on a TCP port, answers SCPI from a
+// spectrum analyzer model and renders a screen reacting to KEYP and touch.
+//
+//	cellsim -addr localhost:6600
+//	cd examples && go run . -celladdr localhost -cellport :6600
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/tomahawk28/cell"
+	"github.com/tomahawk28/cell/celltest"
+)
+
+var (
+	addr     = flag.String("addr", "localhost:6600", "Listen Address, CellAdvisor uses port 66")
+	points   = flag.Int("points", 601, "Points of every sweep")
+	scenario = flag.String("scenario", "", "JSON fault scenario file")
+)
+
+func main() {
+	flag.Parse()
+
+	in := celltest.NewInstrument()
+	in.SetPoints(*points)
+	in.AddCarrier(celltest.Signal{Frequency: 1.8325 * cell.GHz, Bandwidth: 10 * cell.MHz, Power: -55})
+	in.AddCarrier(celltest.Signal{Frequency: 1.8675 * cell.GHz, Bandwidth: 5 * cell.MHz, Power: -62})
+	in.AddInterferer(celltest.Signal{Frequency: 1.851 * cell.GHz, Power: -48})
+	// bring the noise floor onto the screen
+	in.HandleSCPI(":DISP:WIND:TRAC:Y:RLEV -30 DBM")
+
+	server, err := celltest.NewServerAt(*addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	panel := newFrontPanel(in)
+	server.SetInstrument(in)
+	server.HandleSCPI(panel.handleSCPI)
+	server.HandleScreen(panel.render)
+	server.SetMaxPayloadSize(cell.DefaultMaxPayloadSize)
+
+	if *scenario != "" {
+		faults, err := celltest.LoadScenario(*scenario)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.SetScenario(faults)
+	}
+
+	log.Printf("Simulated CellAdvisor listening on %s", server.Addr)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	server.Close()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomahawk28/cell/celltest"
+)
+
+// Screen layout, in pixels of the 640x480 capture
+var (
+	screenRect    = image.Rect(0, 0, 640, 480)
+	headerRect    = image.Rect(0, 0, 520, 32)
+	graticuleRect = image.Rect(20, 48, 500, 448)
+	keyPanelRect  = image.Rect(520, 0, 640, 480)
+)
+
+const (
+	keyHeight      = 56
+	divisions      = 10
+	levelDivisions = 8
+	dbPerDivision  = 10
+	// highlight is how long a pressed key stays lit
+	highlight = 2 * time.Second
+)
+
+var (
+	backgroundColor = color.RGBA{0x10, 0x14, 0x20, 0xff}
+	gridColor       = color.RGBA{0x40, 0x48, 0x58, 0xff}
+	traceColor      = color.RGBA{0xf0, 0xd0, 0x20, 0xff}
+	textColor       = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	keyColor        = color.RGBA{0x30, 0x38, 0x50, 0xff}
+	pressedColor    = color.RGBA{0x20, 0x90, 0xe0, 0xff}
+	touchColor      = color.RGBA{0xe0, 0x30, 0x30, 0xff}
+)
+
+// softKeys are labels of the keys drawn on the right side,
+// KEYP:<label> presses them like touching them does
+var softKeys = []string{"FREQ", "SPAN", "AMP", "BW", "TRACE", "MARKER", "MODE", "PRESET"}
+
+// frontPanel reacts to KEYP and touch commands on top of an instrument,
+// and renders what its screen shows
+type frontPanel struct {
+	instrument *celltest.Instrument
+
+	mu        sync.Mutex
+	lastKey   string
+	pressedAt time.Time
+	touch     image.Point
+	touched   bool
+}
+
+func newFrontPanel(in *celltest.Instrument) *frontPanel {
+	return &frontPanel{instrument: in}
+}
+
+// handleSCPI is a celltest.SCPIHandler catching front panel input
+// before the instrument answers the commands
+func (p *frontPanel) handleSCPI(scpicmd string) string {
+	for _, command := range strings.Split(scpicmd, ";") {
+		command = strings.TrimSpace(command)
+		if !strings.HasPrefix(strings.ToUpper(command), "KEYP") {
+			continue
+		}
+		if key := strings.TrimPrefix(command[4:], ":"); key != command[4:] {
+			p.press(strings.ToUpper(strings.TrimSpace(key)))
+			continue
+		}
+		fields := strings.Fields(command[4:])
+		if len(fields) != 2 {
+			continue
+		}
+		x, errx := strconv.Atoi(fields[0])
+		y, erry := strconv.Atoi(fields[1])
+		if errx == nil && erry == nil {
+			p.touchAt(image.Pt(x, y))
+		}
+	}
+	return p.instrument.HandleSCPI(scpicmd)
+}
+
+// press lights key and runs what it stands for
+func (p *frontPanel) press(key string) {
+	log.Printf("key %s pressed", key)
+	p.mu.Lock()
+	p.lastKey, p.pressedAt = key, time.Now()
+	p.mu.Unlock()
+
+	switch key {
+	case "PRESET":
+		p.instrument.HandleSCPI("*RST")
+	case "UP", "DOWN":
+		center, span := p.query(":SENS:FREQ:CENT?"), p.query(":SENS:FREQ:SPAN?")
+		if key == "DOWN" {
+			span = -span
+		}
+		p.instrument.HandleSCPI(fmt.Sprintf(":SENS:FREQ:CENT %f HZ", center+span/divisions))
+	}
+}
+
+// touchAt marks the touched point, pressing the soft key under it
+func (p *frontPanel) touchAt(at image.Point) {
+	log.Printf("screen touched at %d,%d", at.X, at.Y)
+	p.mu.Lock()
+	p.touch, p.touched = at, true
+	p.mu.Unlock()
+	if at.In(keyPanelRect) {
+		if i := at.Y / keyHeight; i < len(softKeys) {
+			p.press(softKeys[i])
+		}
+	}
+}
+
+// query returning a numeric setting of the instrument, zero if unknown
+func (p *frontPanel) query(scpicmd string) float64 {
+	v, _ := strconv.ParseFloat(strings.TrimSpace(p.instrument.HandleSCPI(scpicmd)), 64)
+	return v
+}
+
+// render draws the screen: settings, graticule with a fresh sweep
+// and soft keys, pressed one and last touch highlighted
+func (p *frontPanel) render() []byte {
+	img := image.NewRGBA(screenRect)
+	fill(img, screenRect, backgroundColor)
+
+	trace := p.instrument.Trace()
+	refLevel := p.query(":DISP:WIND:TRAC:Y:RLEV?")
+	p.mu.Lock()
+	lastKey, pressed := p.lastKey, time.Since(p.pressedAt) < highlight
+	touch, touched := p.touch, p.touched
+	p.mu.Unlock()
+
+	drawText(img, headerRect.Min.Add(image.Pt(8, 4)), p.instrument.Mode(), 2, textColor)
+	drawText(img, headerRect.Min.Add(image.Pt(8, 18)),
+		fmt.Sprintf("CENTER %.3f MHZ  SPAN %.3f MHZ  RBW %.0f KHZ", trace.CenterFrequency/1e6, trace.Span/1e6, trace.RBW/1e3), 1, textColor)
+	if lastKey != "" {
+		label := "KEY " + lastKey
+		drawText(img, image.Pt(headerRect.Max.X-textWidth(label, 2)-8, 4), label, 2, textColor)
+	}
+	drawText(img, image.Pt(graticuleRect.Min.X, graticuleRect.Max.Y+10), fmt.Sprintf("REF %.1f DBM  10 DB/DIV", refLevel), 1, textColor)
+
+	drawGraticule(img)
+	drawTrace(img, trace.Powertrace, refLevel)
+
+	for i, key := range softKeys {
+		r := image.Rect(keyPanelRect.Min.X+4, i*keyHeight+4, keyPanelRect.Max.X-4, (i+1)*keyHeight-4)
+		c := keyColor
+		if pressed && key == lastKey {
+			c = pressedColor
+		}
+		fill(img, r, c)
+		drawText(img, image.Pt(r.Min.X+(r.Dx()-textWidth(key, 2))/2, r.Min.Y+(r.Dy()-10)/2), key, 2, textColor)
+	}
+
+	if touched {
+		line(img, touch.Sub(image.Pt(8, 0)), touch.Add(image.Pt(8, 0)), touchColor)
+		line(img, touch.Sub(image.Pt(0, 8)), touch.Add(image.Pt(0, 8)), touchColor)
+	}
+
+	data, err := celltest.EncodeScreen(img)
+	if err != nil {
+		log.Println("screen encoding failed: ", err.Error())
+	}
+	return data
+}
+
+func drawGraticule(img *image.RGBA) {
+	r := graticuleRect
+	for i := 0; i <= divisions; i++ {
+		x := r.Min.X + i*r.Dx()/divisions
+		line(img, image.Pt(x, r.Min.Y), image.Pt(x, r.Max.Y), gridColor)
+	}
+	for i := 0; i <= levelDivisions; i++ {
+		y := r.Min.Y + i*r.Dy()/levelDivisions
+		line(img, image.Pt(r.Min.X, y), image.Pt(r.Max.X, y), gridColor)
+	}
+}
+
+// drawTrace draws points in dBm with refLevel at the top of the graticule
+func drawTrace(img *image.RGBA, points []float32, refLevel float64) {
+	if len(points) < 2 {
+		return
+	}
+	r := graticuleRect
+	bottom := refLevel - dbPerDivision*levelDivisions
+	at := func(i int) image.Point {
+		level := float64(points[i])
+		if level > refLevel {
+			level = refLevel
+		} else if level < bottom {
+			level = bottom
+		}
+		return image.Pt(
+			r.Min.X+i*(r.Dx()-1)/(len(points)-1),
+			r.Min.Y+int((refLevel-level)/(refLevel-bottom)*float64(r.Dy()-1)),
+		)
+	}
+	for i := 1; i < len(points); i++ {
+		line(img, at(i-1), at(i), traceColor)
+	}
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, &image.Uniform{C: c}, image.Point{}, draw.Src)
+}
+
+// line draws a straight line from a to b, both ends included
+func line(img *image.RGBA, a, b image.Point, c color.Color) {
+	dx, dy := abs(b.X-a.X), -abs(b.Y-a.Y)
+	sx, sy := sign(b.X-a.X), sign(b.Y-a.Y)
+	err := dx + dy
+	for {
+		img.Set(a.X, a.Y, c)
+		if a == b {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			a.X += sx
+		}
+		if e2 <= dx {
+			err += dx
+			a.Y += sy
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func sign(v int) int {
+	switch {
+	case v < 0:
+		return -1
+	case v > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,294 @@
+package cell
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Frequency multipliers, SetCenterFrequency(1.85 * GHz)
+const (
+	Hz  = 1.0
+	KHz = 1e3 * Hz
+	MHz = 1e6 * Hz
+	GHz = 1e9 * Hz
+)
+
+// Detector represents spectrum analyzer detector type
+type Detector string
+
+// Detector types, in their SCPI short form
+const (
+	DetectorNormal   Detector = "NORM"
+	DetectorPeak     Detector = "POS"
+	DetectorNegative Detector = "NEG"
+	DetectorSample   Detector = "SAMP"
+	DetectorRMS      Detector = "RMS"
+	DetectorAverage  Detector = "AVER"
+)
+
+// TraceMode represents how a trace is updated on every sweep
+type TraceMode string
+
+// Trace modes, in their SCPI short form
+const (
+	TraceClearWrite TraceMode = "WRIT"
+	TraceMaxHold    TraceMode = "MAXH"
+	TraceMinHold    TraceMode = "MINH"
+	TraceAverage    TraceMode = "AVER"
+	TraceView       TraceMode = "VIEW"
+	TraceBlank      TraceMode = "BLAN"
+)
+
+// SCPI commands behind the typed control API
+const (
+	scpiCenterFrequency = ":SENS:FREQ:CENT"
+	scpiSpan            = ":SENS:FREQ:SPAN"
+	scpiStartFrequency  = ":SENS:FREQ:STAR"
+	scpiStopFrequency   = ":SENS:FREQ:STOP"
+	scpiRBW             = ":SENS:BAND:RES"
+	scpiVBW             = ":SENS:BAND:VID"
+	scpiReferenceLevel  = ":DISP:WIND:TRAC:Y:RLEV"
+	scpiAttenuation     = ":INP:ATT"
+	scpiDetector        = ":SENS:DET:FUNC"
+	scpiAverageCount    = ":SENS:AVER:COUN"
+	scpiAverageState    = ":SENS:AVER:STAT"
+)
+
+// formatSCPIValue formats value with its SCPI unit suffix, e.g. 1850000000 HZ
+func formatSCPIValue(value float64, unit string) string {
+	return strconv.FormatFloat(value, 'f', -1, 64) + " " + unit
+}
+
+func (cl *CellAdvisor) setSCPI(ctx context.Context, scpicmd, value string) error {
+	_, err := cl.SendSCPIContext(ctx, scpicmd+" "+value)
+	return err
+}
+
+// SetCenterFrequency sets center frequency in Hz
+func (cl *CellAdvisor) SetCenterFrequency(hz float64) error {
+	return cl.SetCenterFrequencyContext(context.Background(), hz)
+}
+
+// SetCenterFrequencyContext is like SetCenterFrequency, but gives up once ctx is done
+func (cl *CellAdvisor) SetCenterFrequencyContext(ctx context.Context, hz float64) error {
+	return cl.setSCPI(ctx, scpiCenterFrequency, formatSCPIValue(hz, "HZ"))
+}
+
+// CenterFrequency returning center frequency in Hz
+func (cl *CellAdvisor) CenterFrequency() (float64, error) {
+	return cl.CenterFrequencyContext(context.Background())
+}
+
+// CenterFrequencyContext is like CenterFrequency, but gives up once ctx is done
+func (cl *CellAdvisor) CenterFrequencyContext(ctx context.Context) (float64, error) {
+	return cl.QuerySCPIFloatContext(ctx, scpiCenterFrequency+"?")
+}
+
+// SetSpan sets frequency span in Hz
+func (cl *CellAdvisor) SetSpan(hz float64) error {
+	return cl.SetSpanContext(context.Background(), hz)
+}
+
+// SetSpanContext is like SetSpan, but gives up once ctx is done
+func (cl *CellAdvisor) SetSpanContext(ctx context.Context, hz float64) error {
+	return cl.setSCPI(ctx, scpiSpan, formatSCPIValue(hz, "HZ"))
+}
+
+// Span returning frequency span in Hz
+func (cl *CellAdvisor) Span() (float64, error) {
+	return cl.SpanContext(context.Background())
+}
+
+// SpanContext is like Span, but gives up once ctx is done
+func (cl *CellAdvisor) SpanContext(ctx context.Context) (float64, error) {
+	return cl.QuerySCPIFloatContext(ctx, scpiSpan+"?")
+}
+
+// SetStartStop sets start and stop frequencies in Hz at once
+func (cl *CellAdvisor) SetStartStop(start, stop float64) error {
+	return cl.SetStartStopContext(context.Background(), start, stop)
+}
+
+// SetStartStopContext is like SetStartStop, but gives up once ctx is done
+func (cl *CellAdvisor) SetStartStopContext(ctx context.Context, start, stop float64) error {
+	if start >= stop {
+		return fmt.Errorf("cell: start frequency %v Hz is not below stop frequency %v Hz", start, stop)
+	}
+	_, err := cl.SendSCPIContext(ctx, scpiStartFrequency+" "+formatSCPIValue(start, "HZ")+
+		";"+scpiStopFrequency+" "+formatSCPIValue(stop, "HZ"))
+	return err
+}
+
+// StartStop returning start and stop frequencies in Hz
+func (cl *CellAdvisor) StartStop() (float64, float64, error) {
+	return cl.StartStopContext(context.Background())
+}
+
+// StartStopContext is like StartStop, but gives up once ctx is done
+func (cl *CellAdvisor) StartStopContext(ctx context.Context) (float64, float64, error) {
+	start, err := cl.QuerySCPIFloatContext(ctx, scpiStartFrequency+"?")
+	if err != nil {
+		return 0, 0, err
+	}
+	stop, err := cl.QuerySCPIFloatContext(ctx, scpiStopFrequency+"?")
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, stop, nil
+}
+
+// SetRBW sets resolution bandwidth in Hz
+func (cl *CellAdvisor) SetRBW(hz float64) error {
+	return cl.SetRBWContext(context.Background(), hz)
+}
+
+// SetRBWContext is like SetRBW, but gives up once ctx is done
+func (cl *CellAdvisor) SetRBWContext(ctx context.Context, hz float64) error {
+	return cl.setSCPI(ctx, scpiRBW, formatSCPIValue(hz, "HZ"))
+}
+
+// RBW returning resolution bandwidth in Hz
+func (cl *CellAdvisor) RBW() (float64, error) {
+	return cl.RBWContext(context.Background())
+}
+
+// RBWContext is like RBW, but gives up once ctx is done
+func (cl *CellAdvisor) RBWContext(ctx context.Context) (float64, error) {
+	return cl.QuerySCPIFloatContext(ctx, scpiRBW+"?")
+}
+
+// SetVBW sets video bandwidth in Hz
+func (cl *CellAdvisor) SetVBW(hz float64) error {
+	return cl.SetVBWContext(context.Background(), hz)
+}
+
+// SetVBWContext is like SetVBW, but gives up once ctx is done
+func (cl *CellAdvisor) SetVBWContext(ctx context.Context, hz float64) error {
+	return cl.setSCPI(ctx, scpiVBW, formatSCPIValue(hz, "HZ"))
+}
+
+// VBW returning video bandwidth in Hz
+func (cl *CellAdvisor) VBW() (float64, error) {
+	return cl.VBWContext(context.Background())
+}
+
+// VBWContext is like VBW, but gives up once ctx is done
+func (cl *CellAdvisor) VBWContext(ctx context.Context) (float64, error) {
+	return cl.QuerySCPIFloatContext(ctx, scpiVBW+"?")
+}
+
+// SetReferenceLevel sets reference level in dBm
+func (cl *CellAdvisor) SetReferenceLevel(dbm float64) error {
+	return cl.SetReferenceLevelContext(context.Background(), dbm)
+}
+
+// SetReferenceLevelContext is like SetReferenceLevel, but gives up once ctx is done
+func (cl *CellAdvisor) SetReferenceLevelContext(ctx context.Context, dbm float64) error {
+	return cl.setSCPI(ctx, scpiReferenceLevel, formatSCPIValue(dbm, "DBM"))
+}
+
+// ReferenceLevel returning reference level in dBm
+func (cl *CellAdvisor) ReferenceLevel() (float64, error) {
+	return cl.ReferenceLevelContext(context.Background())
+}
+
+// ReferenceLevelContext is like ReferenceLevel, but gives up once ctx is done
+func (cl *CellAdvisor) ReferenceLevelContext(ctx context.Context) (float64, error) {
+	return cl.QuerySCPIFloatContext(ctx, scpiReferenceLevel+"?")
+}
+
+// SetAttenuation sets input attenuation in dB
+func (cl *CellAdvisor) SetAttenuation(db float64) error {
+	return cl.SetAttenuationContext(context.Background(), db)
+}
+
+// SetAttenuationContext is like SetAttenuation, but gives up once ctx is done
+func (cl *CellAdvisor) SetAttenuationContext(ctx context.Context, db float64) error {
+	return cl.setSCPI(ctx, scpiAttenuation, formatSCPIValue(db, "DB"))
+}
+
+// Attenuation returning input attenuation in dB
+func (cl *CellAdvisor) Attenuation() (float64, error) {
+	return cl.AttenuationContext(context.Background())
+}
+
+// AttenuationContext is like Attenuation, but gives up once ctx is done
+func (cl *CellAdvisor) AttenuationContext(ctx context.Context) (float64, error) {
+	return cl.QuerySCPIFloatContext(ctx, scpiAttenuation+"?")
+}
+
+// SetDetector sets detector type
+func (cl *CellAdvisor) SetDetector(detector Detector) error {
+	return cl.SetDetectorContext(context.Background(), detector)
+}
+
+// SetDetectorContext is like SetDetector, but gives up once ctx is done
+func (cl *CellAdvisor) SetDetectorContext(ctx context.Context, detector Detector) error {
+	return cl.setSCPI(ctx, scpiDetector, string(detector))
+}
+
+// Detector returning detector type
+func (cl *CellAdvisor) Detector() (Detector, error) {
+	return cl.DetectorContext(context.Background())
+}
+
+// DetectorContext is like Detector, but gives up once ctx is done
+func (cl *CellAdvisor) DetectorContext(ctx context.Context) (Detector, error) {
+	ret, err := cl.QuerySCPIContext(ctx, scpiDetector+"?")
+	return Detector(strings.ToUpper(ret)), err
+}
+
+// SetTraceMode sets update mode of trace, numbered from 1
+func (cl *CellAdvisor) SetTraceMode(trace int, mode TraceMode) error {
+	return cl.SetTraceModeContext(context.Background(), trace, mode)
+}
+
+// SetTraceModeContext is like SetTraceMode, but gives up once ctx is done
+func (cl *CellAdvisor) SetTraceModeContext(ctx context.Context, trace int, mode TraceMode) error {
+	return cl.setSCPI(ctx, fmt.Sprintf(":TRAC%d:MODE", trace), string(mode))
+}
+
+// TraceMode returning update mode of trace, numbered from 1
+func (cl *CellAdvisor) TraceMode(trace int) (TraceMode, error) {
+	return cl.TraceModeContext(context.Background(), trace)
+}
+
+// TraceModeContext is like TraceMode, but gives up once ctx is done
+func (cl *CellAdvisor) TraceModeContext(ctx context.Context, trace int) (TraceMode, error) {
+	ret, err := cl.QuerySCPIContext(ctx, fmt.Sprintf(":TRAC%d:MODE?", trace))
+	return TraceMode(strings.ToUpper(ret)), err
+}
+
+// SetAveraging sets the number of sweeps averaged, zero turns averaging off
+func (cl *CellAdvisor) SetAveraging(count int) error {
+	return cl.SetAveragingContext(context.Background(), count)
+}
+
+// SetAveragingContext is like SetAveraging, but gives up once ctx is done
+func (cl *CellAdvisor) SetAveragingContext(ctx context.Context, count int) error {
+	if count < 0 {
+		return fmt.Errorf("cell: negative average count %d", count)
+	}
+	if count == 0 {
+		return cl.setSCPI(ctx, scpiAverageState, "OFF")
+	}
+	_, err := cl.SendSCPIContext(ctx, fmt.Sprintf("%s %d;%s ON", scpiAverageCount, count, scpiAverageState))
+	return err
+}
+
+// Averaging returning the number of sweeps averaged, zero if averaging is off
+func (cl *CellAdvisor) Averaging() (int, error) {
+	return cl.AveragingContext(context.Background())
+}
+
+// AveragingContext is like Averaging, but gives up once ctx is done
+func (cl *CellAdvisor) AveragingContext(ctx context.Context) (int, error) {
+	enabled, err := cl.QuerySCPIBoolContext(ctx, scpiAverageState+"?")
+	if err != nil || !enabled {
+		return 0, err
+	}
+	count, err := cl.QuerySCPIIntContext(ctx, scpiAverageCount+"?")
+	return int(count), err
+}
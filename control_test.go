@@ -0,0 +1,102 @@
+package cell
+
+import (
+	"strings"
+	"testing"
+)
+
+// settingsDevice answers queries with the value last set by the same header,
+// unit suffix removed, and reports every setting command on the returned channel
+func settingsDevice(t *testing.T) (*CellAdvisor, <-chan string) {
+	settings, sets := map[string]string{}, make(chan string, 16)
+	cl := fakeSCPIDevice(t, func(scpicmd string) string {
+		for _, command := range strings.Split(scpicmd, ";") {
+			if header := strings.TrimSuffix(command, "?"); header != command {
+				return settings[header]
+			}
+			sets <- command
+			fields := strings.Fields(command)
+			settings[fields[0]] = fields[1]
+		}
+		return ""
+	})
+	return cl, sets
+}
+
+func TestControlFrequencies(t *testing.T) {
+	cl, sets := settingsDevice(t)
+	defer cl.Close()
+
+	if err := cl.SetCenterFrequency(1.85 * GHz); err != nil {
+		t.Fatal(err)
+	}
+	if sent := <-sets; sent != ":SENS:FREQ:CENT 1850000000 HZ" {
+		t.Fatalf("sent %q", sent)
+	}
+	if hz, err := cl.CenterFrequency(); err != nil || hz != 1.85e9 {
+		t.Fatalf("CenterFrequency = %v, %v", hz, err)
+	}
+
+	if err := cl.SetStartStop(800*MHz, 900*MHz); err != nil {
+		t.Fatal(err)
+	}
+	if sent := <-sets + ";" + <-sets; sent != ":SENS:FREQ:STAR 800000000 HZ;:SENS:FREQ:STOP 900000000 HZ" {
+		t.Fatalf("sent %q", sent)
+	}
+	if start, stop, err := cl.StartStop(); err != nil || start != 800e6 || stop != 900e6 {
+		t.Fatalf("StartStop = %v, %v, %v", start, stop, err)
+	}
+	if err := cl.SetStartStop(900*MHz, 800*MHz); err == nil {
+		t.Fatal("SetStartStop accepted start above stop")
+	}
+
+	if err := cl.SetRBW(30 * KHz); err != nil {
+		t.Fatal(err)
+	}
+	if sent := <-sets; sent != ":SENS:BAND:RES 30000 HZ" {
+		t.Fatalf("sent %q", sent)
+	}
+	if err := cl.SetReferenceLevel(-10.5); err != nil {
+		t.Fatal(err)
+	}
+	if sent := <-sets; sent != ":DISP:WIND:TRAC:Y:RLEV -10.5 DBM" {
+		t.Fatalf("sent %q", sent)
+	}
+	if dbm, err := cl.ReferenceLevel(); err != nil || dbm != -10.5 {
+		t.Fatalf("ReferenceLevel = %v, %v", dbm, err)
+	}
+}
+
+func TestControlModes(t *testing.T) {
+	cl, sets := settingsDevice(t)
+	defer cl.Close()
+
+	if err := cl.SetDetector(DetectorPeak); err != nil {
+		t.Fatal(err)
+	}
+	<-sets
+	if detector, err := cl.Detector(); err != nil || detector != DetectorPeak {
+		t.Fatalf("Detector = %v, %v", detector, err)
+	}
+	if err := cl.SetTraceMode(2, TraceMaxHold); err != nil {
+		t.Fatal(err)
+	}
+	if sent := <-sets; sent != ":TRAC2:MODE MAXH" {
+		t.Fatalf("sent %q", sent)
+	}
+	if mode, err := cl.TraceMode(2); err != nil || mode != TraceMaxHold {
+		t.Fatalf("TraceMode = %v, %v", mode, err)
+	}
+	if err := cl.SetAveraging(16); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := cl.Averaging(); err != nil || count != 16 {
+		t.Fatalf("Averaging = %v, %v", count, err)
+	}
+	if err := cl.SetAveraging(0); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := cl.Averaging(); err != nil || count != 0 {
+		t.Fatalf("Averaging after off = %v, %v", count, err)
+	}
+}
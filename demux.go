@@ -0,0 +1,256 @@
+package cell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrDemuxActive is returned by GetMessage while a background reader
+// owns the connection, replies are then only available through Transact
+var ErrDemuxActive = errors.New("cell: connection is read by the demultiplexer, use Transact")
+
+// Event represents a message CellAdvisor sent on its own,
+// not answering any pending request
+type Event struct {
+	// Command is the command byte of the message
+	Command byte
+	// Payload is the reassembled message data
+	Payload []byte
+	// Time is when the last frame of the message arrived
+	Time time.Time
+}
+
+type demuxReply struct {
+	data        []byte
+	checksumErr *ChecksumError
+	err         error
+}
+
+// demuxer owns the reading side of one connection, handing complete
+// messages to pending requests of the same command byte in order
+type demuxer struct {
+	cl      *CellAdvisor
+	decoder *Decoder
+	mu      sync.Mutex
+	pending map[byte][]chan demuxReply
+	// err is set once the reader stopped, failing any later request
+	err  error
+	done chan struct{}
+}
+
+// messageAssembly collects frames of a message being received
+type messageAssembly struct {
+	frames int
+	reply  demuxReply
+}
+
+func startDemuxer(cl *CellAdvisor) *demuxer {
+	d := &demuxer{
+		cl:      cl,
+		decoder: cl.decoder,
+		pending: map[byte][]chan demuxReply{},
+		done:    make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *demuxer) run() {
+	defer close(d.done)
+	partial := map[byte]*messageAssembly{}
+	for {
+		frame, err := d.decoder.Decode()
+		if errors.Is(err, ErrMalformedFrame) || errors.Is(err, ErrFrameTooLarge) {
+			log.Println("demultiplexer dropped frame: ", err.Error())
+			continue
+		}
+		if err != nil {
+			d.fail(err)
+			return
+		}
+
+		msg := partial[frame.Command]
+		if msg == nil {
+			msg = &messageAssembly{}
+			partial[frame.Command] = msg
+		}
+		if msg.frames++; msg.frames > int(frame.Total) && msg.frames > 1 {
+			delete(partial, frame.Command)
+			d.deliver(frame.Command, demuxReply{err: fmt.Errorf("%w: frame %d of %d", ErrTooManyFrames, msg.frames, frame.Total)})
+			continue
+		}
+		if realchecksum := frame.Sum(); realchecksum != frame.Checksum {
+			d.cl.checksumFailures.Add(1)
+			if msg.reply.checksumErr == nil {
+				msg.reply.checksumErr = &ChecksumError{Command: frame.Command, Expected: realchecksum, Actual: frame.Checksum}
+			}
+		}
+		msg.reply.data = append(msg.reply.data, frame.Payload...)
+		if frame.Last() {
+			delete(partial, frame.Command)
+			d.deliver(frame.Command, msg.reply)
+		}
+	}
+}
+
+// deliver hands a message to the oldest request waiting for cmd,
+// or publishes it as an Event if nobody waits
+func (d *demuxer) deliver(cmd byte, reply demuxReply) {
+	d.mu.Lock()
+	queue := d.pending[cmd]
+	if len(queue) == 0 {
+		d.mu.Unlock()
+		if reply.err == nil {
+			d.cl.publish(Event{Command: cmd, Payload: reply.data, Time: time.Now()})
+		}
+		return
+	}
+	d.pending[cmd] = queue[1:]
+	d.mu.Unlock()
+	queue[0] <- reply
+}
+
+// fail stops every pending request with err
+func (d *demuxer) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.err = err
+	for cmd, queue := range d.pending {
+		for _, ch := range queue {
+			ch <- demuxReply{err: err}
+		}
+		delete(d.pending, cmd)
+	}
+}
+
+// register queues a request waiting for a reply to cmd
+func (d *demuxer) register(cmd byte) (chan demuxReply, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return nil, d.err
+	}
+	// buffered, so an abandoned request never blocks the reader
+	ch := make(chan demuxReply, 1)
+	d.pending[cmd] = append(d.pending[cmd], ch)
+	return ch, nil
+}
+
+// unregister removes a request whose reply will never come
+func (d *demuxer) unregister(cmd byte, ch chan demuxReply) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	queue := d.pending[cmd]
+	for i := range queue {
+		if queue[i] == ch {
+			d.pending[cmd] = append(queue[:i:i], queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// wait blocks until the reply for ch arrives, applying checksum policy
+func (d *demuxer) wait(ctx context.Context, ch chan demuxReply, policy ChecksumPolicy) ([]byte, error) {
+	select {
+	case reply := <-ch:
+		if reply.err != nil {
+			return nil, reply.err
+		}
+		if reply.checksumErr != nil {
+			if policy != ChecksumLog {
+				return nil, reply.checksumErr
+			}
+			log.Printf("checksum required to be: %c, but %c", reply.checksumErr.Expected, reply.checksumErr.Actual)
+		}
+		return reply.data, nil
+	case <-ctx.Done():
+		// the request stays queued, so its late reply is not taken for the next one
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// SetDemux turns demultiplexing on or off. While on, a background
+// goroutine reads the connection and matches every reply to pending
+// Transact calls by command byte and order, any other message is
+// published to Subscribe channels. The mode survives Reinitialize
+func (cl *CellAdvisor) SetDemux(enabled bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if enabled == cl.demuxEnabled {
+		return
+	}
+	cl.demuxEnabled = enabled
+	if cl.conn == nil {
+		return
+	}
+	if enabled {
+		cl.demux = startDemuxer(cl)
+		return
+	}
+	// a deadline in the past stops the reader without closing the connection
+	cl.conn.SetReadDeadline(time.Unix(1, 0))
+	<-cl.demux.done
+	cl.conn.SetReadDeadline(time.Time{})
+	cl.demux = nil
+}
+
+// Subscribe returns a channel receiving unsolicited messages while
+// demultiplexing, and a function ending the subscription.
+// Events are dropped when the channel buffer is full
+func (cl *CellAdvisor) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	cl.subscribersMu.Lock()
+	if cl.subscribers == nil {
+		cl.subscribers = map[chan Event]struct{}{}
+	}
+	cl.subscribers[ch] = struct{}{}
+	cl.subscribersMu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			cl.subscribersMu.Lock()
+			defer cl.subscribersMu.Unlock()
+			delete(cl.subscribers, ch)
+			close(ch)
+		})
+	}
+}
+
+func (cl *CellAdvisor) publish(event Event) {
+	cl.subscribersMu.Lock()
+	defer cl.subscribersMu.Unlock()
+	for ch := range cl.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// roundTripDemux sends a request and waits for the reader to hand over
+// its reply, releasing cl.mu meanwhile when pipelined
+func (cl *CellAdvisor) roundTripDemux(ctx context.Context, cmd byte, payload string, pipelined bool) ([]byte, error) {
+	d := cl.demux
+	ch, err := d.register(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cl.sendMessage(ctx, cmd, payload); err != nil {
+		d.unregister(cmd, ch)
+		return nil, err
+	}
+	policy := cl.checksumPolicy
+	if pipelined {
+		cl.mu.Unlock()
+		defer cl.mu.Lock()
+	}
+	return d.wait(ctx, ch, policy)
+}
@@ -0,0 +1,128 @@
+package cell
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// pushingCellAdvisor returns a CellAdvisor whose device echoes every request,
+// sending an unsolicited 0x90 message right before the first reply
+func pushingCellAdvisor(t *testing.T) (*CellAdvisor, net.Conn) {
+	devices := make(chan net.Conn, 1)
+	cl := pipeCellAdvisor(t, func(device net.Conn) {
+		devices <- device
+		defer device.Close()
+		dec, enc := NewDecoder(device), NewEncoder(device)
+		pushed := false
+		for {
+			f, err := dec.Decode()
+			if err != nil {
+				return
+			}
+			if !pushed {
+				pushed = true
+				if err := enc.Encode(NewFrame(0x90, []byte("alarm"))); err != nil {
+					return
+				}
+			}
+			if err := enc.Encode(f); err != nil {
+				return
+			}
+		}
+	})
+	return cl, <-devices
+}
+
+func TestDemuxUnsolicitedEvent(t *testing.T) {
+	cl, _ := pushingCellAdvisor(t)
+	defer cl.Close()
+	cl.SetDemux(true)
+	events, cancel := cl.Subscribe(1)
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		if ret, err := cl.Transact(0x50, "ping"); err != nil || string(ret) != "ping" {
+			t.Fatalf("Transact = %q, %v", ret, err)
+		}
+	}
+	select {
+	case event := <-events:
+		if event.Command != 0x90 || string(event.Payload) != "alarm" {
+			t.Fatalf("event = %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unsolicited message not published")
+	}
+	if _, err := cl.GetMessage(); err != ErrDemuxActive {
+		t.Fatalf("GetMessage error = %v, want %v", err, ErrDemuxActive)
+	}
+
+	cl.SetDemux(false)
+	if ret, err := cl.Transact(0x50, "pong"); err != nil || string(ret) != "pong" {
+		t.Fatalf("Transact after SetDemux(false) = %q, %v", ret, err)
+	}
+}
+
+func TestDemuxConnectionLost(t *testing.T) {
+	cl, device := pushingCellAdvisor(t)
+	defer cl.Close()
+	cl.SetDemux(true)
+	if _, err := cl.Transact(0x50, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	device.Close()
+	if _, err := cl.Transact(0x50, "ping"); err != io.EOF && err != io.ErrClosedPipe {
+		t.Fatalf("error = %v, want connection failure", err)
+	}
+}
+
+func TestTransactPipelined(t *testing.T) {
+	cl := pipeCellAdvisor(t, func(device net.Conn) {
+		defer device.Close()
+		dec, enc := NewDecoder(device), NewEncoder(device)
+		for {
+			// answer only once two requests are in flight
+			first, err := dec.Decode()
+			if err != nil {
+				return
+			}
+			second, err := dec.Decode()
+			if err != nil {
+				return
+			}
+			if enc.Encode(first) != nil || enc.Encode(second) != nil {
+				return
+			}
+		}
+	})
+	defer cl.Close()
+	cl.SetDemux(true)
+
+	results := make(chan string, 2)
+	for _, payload := range []string{"first", "second"} {
+		go func(payload string) {
+			ret, err := cl.Transact(0x61, payload)
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			if string(ret) != payload {
+				results <- "reply " + string(ret) + " for " + payload
+				return
+			}
+			results <- ""
+		}(payload)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case result := <-results:
+			if result != "" {
+				t.Fatal(result)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("requests were not pipelined")
+		}
+	}
+}
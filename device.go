@@ -0,0 +1,121 @@
+package cell
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DeviceInfo represents CellAdvisor identification given by *IDN?
+type DeviceInfo struct {
+	Manufacturer string `json:"Manufacturer"`
+	Model        string `json:"Model"`
+	Serial       string `json:"Serial"`
+	Firmware     string `json:"Firmware"`
+}
+
+// ParseDeviceInfo parses an IEEE 488.2 *IDN? reply,
+// e.g. JDSU,JD745A,123456789,1.02.003
+func ParseDeviceInfo(idn string) (*DeviceInfo, error) {
+	fields := strings.Split(trimSCPIResponse(idn), ",")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("cell: *IDN?: invalid reply %q", idn)
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return &DeviceInfo{
+		Manufacturer: fields[0],
+		Model:        fields[1],
+		Serial:       fields[2],
+		Firmware:     fields[3],
+	}, nil
+}
+
+// DefaultIdentifyTimeout limits the *IDN? query made on connect
+// when DialOptions has no IdentifyTimeout
+const DefaultIdentifyTimeout = 5 * time.Second
+
+// GetDeviceInfo returning identification of connected CellAdvisor,
+// queried on connect and cached until the connection is reinitialized.
+// It is queried again if the device gave an invalid answer on connect
+func (cl *CellAdvisor) GetDeviceInfo() (*DeviceInfo, error) {
+	return cl.GetDeviceInfoContext(context.Background())
+}
+
+// GetDeviceInfoContext is like GetDeviceInfo, but gives up once ctx is done
+func (cl *CellAdvisor) GetDeviceInfoContext(ctx context.Context) (*DeviceInfo, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.info == nil {
+		if err := cl.identify(ctx); err != nil {
+			return nil, err
+		}
+	}
+	info := *cl.info
+	return &info, nil
+}
+
+// identify caches the *IDN? answer, cl.mu must be held
+func (cl *CellAdvisor) identify(ctx context.Context) error {
+	ret, err := cl.transact(ctx, 0x61, "*IDN?\n", false)
+	if err != nil {
+		return err
+	}
+	info, err := ParseDeviceInfo(string(ret))
+	if err != nil {
+		return err
+	}
+	cl.info = info
+	return nil
+}
+
+// DeviceStatus represents the heartbeat message given by CellAdvisor
+type DeviceStatus struct {
+	// Mode is the running measurement mode
+	Mode string `json:"Mode"`
+	// Measurement is the measurement state, e.g. running or hold
+	Measurement string `json:"Measurement"`
+	// Fields keeps every other key/value pair the device reported
+	Fields map[string]string `json:"Fields"`
+	// Raw is the message as received
+	Raw string `json:"Raw"`
+}
+
+// statusPair matches key="value", key=value and key: value pairs
+var statusPair = regexp.MustCompile(`([A-Za-z_][\w.]*)\s*[=:]\s*(?:"([^"]*)"|([^\s,;&"]*))`)
+
+// ParseDeviceStatus parses a 0x50 status payload.
+// Pairs other than mode and measurement state end up in Fields,
+// a payload without any pair only fills Raw
+func ParseDeviceStatus(payload string) *DeviceStatus {
+	status := &DeviceStatus{Fields: map[string]string{}, Raw: payload}
+	for _, pair := range statusPair.FindAllStringSubmatch(payload, -1) {
+		key, value := pair[1], pair[2]+pair[3]
+		switch strings.ToLower(key) {
+		case "mode", "app", "application":
+			status.Mode = value
+		case "meas", "measurement", "state", "measstate":
+			status.Measurement = value
+		default:
+			status.Fields[key] = value
+		}
+	}
+	return status
+}
+
+// GetStatus returning parsed heartbeat message from CellAdvisor
+func (cl *CellAdvisor) GetStatus() (*DeviceStatus, error) {
+	return cl.GetStatusContext(context.Background())
+}
+
+// GetStatusContext is like GetStatus, but gives up once ctx is done
+func (cl *CellAdvisor) GetStatusContext(ctx context.Context) (*DeviceStatus, error) {
+	ret, err := cl.GetStatusMessageContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDeviceStatus(ret), nil
+}
@@ -0,0 +1,126 @@
+package cell
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGetDeviceInfo(t *testing.T) {
+	queries := 0
+	cl := fakeSCPIDevice(t, func(scpicmd string) string {
+		if scpicmd == "*IDN?" {
+			queries++
+			return "JDSU, JD745A,123456789,1.02.003\n"
+		}
+		return ""
+	})
+	defer cl.Close()
+
+	for i := 0; i < 3; i++ {
+		info, err := cl.GetDeviceInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := DeviceInfo{"JDSU", "JD745A", "000000001", "1.00.000"}
+		if *info != want {
+			t.Fatalf("info = %+v, want %+v", *info, want)
+		}
+	}
+	if queries != 0 {
+		t.Fatalf("*IDN? sent %d times after connect, want 0", queries)
+	}
+}
+
+func TestGetDeviceInfoAfterFailedIdentification(t *testing.T) {
+	client, device := net.Pipe()
+	go func() {
+		defer device.Close()
+		dec, enc := NewDecoder(device), NewEncoder(device)
+		for replies := []string{"unknown command\n", "JDSU, JD745A,123456789,1.02.003\n"}; ; replies = replies[1:] {
+			if _, err := dec.Decode(); err != nil || len(replies) == 0 {
+				return
+			}
+			if err := enc.Encode(NewFrame(0x61, []byte(replies[0]))); err != nil {
+				return
+			}
+		}
+	}()
+	cl, err := NewCellAdvisorWithOptions("pipe", DialOptions{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return client, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	info, err := cl.GetDeviceInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (DeviceInfo{"JDSU", "JD745A", "123456789", "1.02.003"}); *info != want {
+		t.Fatalf("info = %+v, want %+v", *info, want)
+	}
+}
+
+func TestParseDeviceInfoInvalid(t *testing.T) {
+	for _, idn := range []string{"", "JDSU", "JDSU,JD745A,123"} {
+		if _, err := ParseDeviceInfo(idn); err == nil {
+			t.Fatalf("ParseDeviceInfo(%q) accepted invalid reply", idn)
+		}
+	}
+}
+
+func TestParseDeviceStatus(t *testing.T) {
+	for payload, want := range map[string]DeviceStatus{
+		`<Status Mode="SA" State="RUN" Battery="87"/>`: {
+			Mode: "SA", Measurement: "RUN", Fields: map[string]string{"Battery": "87"},
+		},
+		"mode=IA;meas=HOLD;temp=31.5": {
+			Mode: "IA", Measurement: "HOLD", Fields: map[string]string{"temp": "31.5"},
+		},
+		"OK": {Fields: map[string]string{}},
+	} {
+		got := ParseDeviceStatus(payload)
+		if got.Mode != want.Mode || got.Measurement != want.Measurement || got.Raw != payload || len(got.Fields) != len(want.Fields) {
+			t.Fatalf("ParseDeviceStatus(%q) = %+v", payload, got)
+		}
+		for k, v := range want.Fields {
+			if got.Fields[k] != v {
+				t.Fatalf("ParseDeviceStatus(%q) field %s = %q, want %q", payload, k, got.Fields[k], v)
+			}
+		}
+	}
+}
+
+func TestIdentificationTimeout(t *testing.T) {
+	client, device := net.Pipe()
+	gone := make(chan error, 1)
+	go func() {
+		defer device.Close()
+		dec, enc := NewDecoder(device), NewEncoder(device)
+		if _, err := dec.Decode(); err != nil {
+			gone <- err
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+		gone <- enc.Encode(NewFrame(0x61, []byte(pipeIDN)))
+	}()
+	cl, err := NewCellAdvisorWithOptions("pipe", DialOptions{
+		IdentifyTimeout: 50 * time.Millisecond,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return client, nil
+		},
+	})
+	if cl != nil || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("NewCellAdvisor = %v, %v, want %v", cl, err, ErrTimeout)
+	}
+	// the late answer finds the connection closed
+	if err := <-gone; err == nil {
+		t.Fatal("late *IDN? answer written to an open connection")
+	}
+}
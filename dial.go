@@ -0,0 +1,112 @@
+package cell
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DialOptions configures how a CellAdvisor connection is made
+// The zero value dials JDProtocolPort with no timeout
+type DialOptions struct {
+	// Port is CellAdvisor TCP port in ":66" form, JDProtocolPort if empty
+	Port string
+	// Timeout limits how long connecting may take, no limit if zero.
+	// It applies with Dialer and DialContext too
+	Timeout time.Duration
+	// KeepAlive is the TCP keep-alive period, system default if zero
+	// and keep-alives are disabled if negative
+	KeepAlive time.Duration
+	// Dialer is used to connect when given, KeepAlive is ignored then
+	Dialer *net.Dialer
+	// DialContext overrides Dialer and KeepAlive,
+	// routing the connection through tunnels or in-memory pipes
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+	// IdentifyTimeout limits the *IDN? query made once connected,
+	// DefaultIdentifyTimeout if zero
+	IdentifyTimeout time.Duration
+}
+
+func (opts DialOptions) address(ip string) string {
+	if opts.Port == "" {
+		return ip + JDProtocolPort
+	}
+	return ip + opts.Port
+}
+
+func (opts DialOptions) dial(ctx context.Context, ip string) (net.Conn, error) {
+	addr := opts.address(ip)
+	dial := opts.DialContext
+	if dial == nil {
+		dialer := opts.Dialer
+		if dialer == nil {
+			dialer = &net.Dialer{Timeout: opts.Timeout, KeepAlive: opts.KeepAlive}
+		}
+		dial = dialer.DialContext
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, newDialError(addr, err)
+	}
+	return conn, nil
+}
+
+// Kinds of connection failures, matched with errors.Is against a *DialError
+var (
+	// ErrConnectionRefused means CellAdvisor actively refused the connection
+	ErrConnectionRefused = errors.New("cell: connection refused")
+	// ErrDialTimeout means CellAdvisor did not answer in time
+	ErrDialTimeout = errors.New("cell: dial timed out")
+	// ErrHostNotFound means CellAdvisor address could not be resolved
+	ErrHostNotFound = errors.New("cell: host not found")
+)
+
+// DialError represents a failed connection attempt to CellAdvisor
+type DialError struct {
+	// Addr is the address that was dialed
+	Addr string
+	// Kind is one of ErrConnectionRefused, ErrDialTimeout, ErrHostNotFound
+	// or nil when the failure could not be classified
+	Kind error
+	// Err is the underlying network error
+	Err error
+}
+
+func (e *DialError) Error() string {
+	return "cell: dial " + e.Addr + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying network error
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of this failure
+func (e *DialError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+func newDialError(addr string, err error) *DialError {
+	var (
+		dnsErr *net.DNSError
+		netErr net.Error
+		kind   error
+	)
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		kind = ErrHostNotFound
+	case errors.Is(err, syscall.ECONNREFUSED):
+		kind = ErrConnectionRefused
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		kind = ErrDialTimeout
+	}
+	return &DialError{Addr: addr, Kind: kind, Err: err}
+}
@@ -29,12 +29,14 @@ import (
 
 	"net/http"
 
+	"github.com/tomahawk28/cell"
 	"github.com/tomahawk28/cell/restful"
 )
 
 var (
 	httpAddr        = flag.String("http", ":8040", "Listen Address")
 	cellAdvisorAddr = flag.String("celladdr", "10.82.26.12", "CellAdvisor Address")
+	cellAdvisorPort = flag.String("cellport", cell.JDProtocolPort, "CellAdvisor Port, :6600 for cellsim")
 	numsport        = flag.Uint("numsport", 4, "The number of ports ")
 	pollPeriod      = flag.Duration("poll", 10*time.Second, "Poll Period")
 	templateFile    = flag.String("template", "./LK2.html", "Template File")
@@ -45,7 +47,10 @@ func main() {
 	flag.Parse()
 	tmpl := template.Must(template.ParseFiles(*templateFile))
 
-	api := restful.NewCellAdvisorServer(int(*numsport), *cellAdvisorAddr, *pollPeriod)
+	api, err := restful.NewCellAdvisorServerWithOptions(int(*numsport), *cellAdvisorAddr, *pollPeriod, cell.DialOptions{Port: *cellAdvisorPort})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	http.Handle("/api/", api)
 
@@ -0,0 +1,269 @@
+package cell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Special bytes of the JD protocol framing.
+// Any of them appearing inside a frame is sent as FrameEscape
+// followed by the original byte XOR 0x20
+const (
+	// FrameStart opens every frame on the wire
+	FrameStart byte = 0x7f
+	// FrameEnd closes every frame on the wire
+	FrameEnd byte = 0x7e
+	// FrameEscape masks the following byte
+	FrameEscape byte = 0x7d
+)
+
+// frameHeader is the leading byte of every unmasked frame body
+const frameHeader = 'C'
+
+const (
+	// DefaultMaxPayloadSize is the largest payload CellAdvisor puts
+	// in a single outgoing frame unless changed by SetMaxPayloadSize
+	DefaultMaxPayloadSize = 1024
+	// DefaultMaxFramePayload is the largest payload a Decoder accepts
+	// in a single incoming frame unless its MaxPayloadSize is set
+	DefaultMaxFramePayload = 64 * 1024
+)
+
+var (
+	// ErrMalformedFrame is returned when raw bytes do not form a JD protocol frame,
+	// errors wrapping it tell what exactly was wrong
+	ErrMalformedFrame = errors.New("cell: malformed frame")
+	// ErrPayloadTooLarge is returned when a payload does not fit in 255 frames
+	ErrPayloadTooLarge = errors.New("cell: payload too large")
+	// ErrFrameTooLarge is returned when an incoming frame exceeds the decoder limit
+	ErrFrameTooLarge = errors.New("cell: frame too large")
+	// ErrTooManyFrames is returned when a message runs past its announced frame total
+	ErrTooManyFrames = errors.New("cell: too many frames in message")
+)
+
+// Frame represents a single JD protocol frame.
+// A message longer than one frame is split over Total frames,
+// each of them carrying its own Index
+type Frame struct {
+	// Command is the request/response type (0x50 status, 0x60 screen, ...)
+	Command byte
+	// Total is the number of frames in the message
+	Total byte
+	// Index is the position of this frame in the message
+	Index byte
+	// Payload is the unmasked data carried by this frame
+	Payload []byte
+	// Checksum is the checksum byte as sent on the wire
+	Checksum byte
+}
+
+// NewFrame creates single frame message with given command and payload,
+// its checksum already computed
+func NewFrame(cmd byte, payload []byte) Frame {
+	f := Frame{Command: cmd, Total: 0x01, Index: 0x01, Payload: payload}
+	f.Checksum = f.Sum()
+	return f
+}
+
+// SplitFrames splits payload over frames carrying at most maxPayload bytes,
+// no limit if maxPayload is not positive. A payload fitting in one frame
+// gives the same single frame as NewFrame, longer ones are indexed from 0
+// so that Last reports the final frame
+func SplitFrames(cmd byte, payload []byte, maxPayload int) ([]Frame, error) {
+	if maxPayload <= 0 || len(payload) <= maxPayload {
+		return []Frame{NewFrame(cmd, payload)}, nil
+	}
+	total := (len(payload) + maxPayload - 1) / maxPayload
+	if total > 0xff {
+		return nil, ErrPayloadTooLarge
+	}
+	frames := make([]Frame, total)
+	for i := range frames {
+		end := (i + 1) * maxPayload
+		if end > len(payload) {
+			end = len(payload)
+		}
+		frames[i] = Frame{Command: cmd, Total: byte(total), Index: byte(i), Payload: payload[i*maxPayload : end]}
+		frames[i].Checksum = frames[i].Sum()
+	}
+	return frames, nil
+}
+
+// Sum computes the checksum the frame should carry
+func (f Frame) Sum() byte {
+	return getChecksum(append([]byte{frameHeader, f.Command, f.Total, f.Index}, f.Payload...))
+}
+
+// Valid reports whether the frame's checksum matches its content
+func (f Frame) Valid() bool {
+	return f.Sum() == f.Checksum
+}
+
+// Last reports whether the frame is the final one of its message
+func (f Frame) Last() bool {
+	return f.Total <= f.Index+1
+}
+
+// MarshalBinary returns the masked wire representation of the frame,
+// including start and end bytes. Checksum is written as is
+func (f Frame) MarshalBinary() ([]byte, error) {
+	body := append([]byte{frameHeader, f.Command, f.Total, f.Index}, f.Payload...)
+	body = append(body, f.Checksum)
+	body = maskingCommandCharacter(body)
+	return append(append([]byte{FrameStart}, body...), FrameEnd), nil
+}
+
+// UnmarshalBinary parses a complete wire frame, start and end bytes included.
+// Frames not starting with the 'C' header are rejected
+func (f *Frame) UnmarshalBinary(data []byte) error {
+	switch {
+	case len(data) == 0 || data[0] != FrameStart:
+		return fmt.Errorf("%w: missing start byte", ErrMalformedFrame)
+	case len(data) < 2 || data[len(data)-1] != FrameEnd:
+		return fmt.Errorf("%w: missing end byte", ErrMalformedFrame)
+	}
+	body := unmaskingCommandCharacter(data[1 : len(data)-1])
+	// header, command, total, index and checksum
+	if len(body) < 5 {
+		return fmt.Errorf("%w: %d bytes is shorter than frame header", ErrMalformedFrame, len(body))
+	}
+	if body[0] != frameHeader {
+		return fmt.Errorf("%w: header %q, want %q", ErrMalformedFrame, body[0], frameHeader)
+	}
+	f.Command, f.Total, f.Index = body[1], body[2], body[3]
+	f.Payload = body[4 : len(body)-1]
+	f.Checksum = body[len(body)-1]
+	return nil
+}
+
+// Encoder writes JD protocol frames to an output stream
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the wire representation of f to the stream
+func (enc *Encoder) Encode(f Frame) error {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write(data)
+	return err
+}
+
+// Decoder reads JD protocol frames from an input stream.
+// Bytes outside of frames are skipped, and a broken frame is reported
+// without losing the frames following it
+type Decoder struct {
+	r *bufio.Reader
+	// MaxPayloadSize limits the payload of a single frame,
+	// DefaultMaxFramePayload if zero
+	MaxPayloadSize int
+}
+
+// NewDecoder returns a new decoder that reads from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next frame from the stream.
+// Checksum is not verified, use Frame.Valid for that.
+// A stream ending inside a frame gives io.ErrUnexpectedEOF
+func (dec *Decoder) Decode() (Frame, error) {
+	var f Frame
+	// resynchronize on the next start byte
+	for {
+		b, err := dec.r.ReadByte()
+		if err != nil {
+			return f, err
+		}
+		if b == FrameStart {
+			break
+		}
+	}
+
+	limit := dec.MaxPayloadSize
+	if limit <= 0 {
+		limit = DefaultMaxFramePayload
+	}
+	// header, command, total, index and checksum around the payload
+	limit += 5
+
+	data, size := []byte{FrameStart}, 0
+	for {
+		b, err := dec.r.ReadByte()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return f, err
+		}
+		switch b {
+		case FrameStart:
+			// keep the start byte for the next Decode
+			dec.r.UnreadByte()
+			return f, fmt.Errorf("%w: frame interrupted by a new start byte", ErrMalformedFrame)
+		case FrameEnd:
+			err = f.UnmarshalBinary(append(data, b))
+			return f, err
+		case FrameEscape:
+			// escapes add nothing to the payload, but an escaped frame
+			// takes at most two bytes for each of its own
+			if len(data) > 2*limit {
+				return f, fmt.Errorf("%w: payload exceeds %d bytes", ErrFrameTooLarge, limit-5)
+			}
+		default:
+			if size++; size > limit {
+				return f, fmt.Errorf("%w: payload exceeds %d bytes", ErrFrameTooLarge, limit-5)
+			}
+		}
+		data = append(data, b)
+	}
+}
+
+func maskingCommandCharacter(data []byte) []byte {
+	var result []byte
+	for _, character := range data {
+		switch character {
+		case FrameEnd, FrameEscape, FrameStart:
+			result = append(result, FrameEscape, 0x20^character)
+		default:
+			result = append(result, character)
+		}
+	}
+	return result
+}
+
+func unmaskingCommandCharacter(data []byte) []byte {
+	var result []byte
+	var masking bool
+	for _, character := range data {
+		switch character {
+		case FrameEscape:
+			masking = true
+		default:
+			if masking {
+				masking = false
+				result = append(result, character^0x20)
+			} else {
+				result = append(result, character)
+			}
+		}
+	}
+	return result
+}
+
+func getChecksum(data []byte) byte {
+	total := 0
+	for _, value := range data {
+		total += int(value)
+	}
+	return byte(total & 0xff)
+}
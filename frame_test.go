@@ -0,0 +1,194 @@
+package cell
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrameEncodeDecode(t *testing.T) {
+	var buf bytes.Buffer
+	enc, dec := NewEncoder(&buf), NewDecoder(&buf)
+	for i := 0; i < 200; i++ {
+		sent := NewFrame(0x61, randomBytes(32))
+		if err := enc.Encode(sent); err != nil {
+			t.Fatal(err)
+		}
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Command != sent.Command || got.Total != sent.Total || got.Index != sent.Index {
+			t.Fatalf("header = %x %x %x, want %x %x %x", got.Command, got.Total, got.Index, sent.Command, sent.Total, sent.Index)
+		}
+		if !bytes.Equal(got.Payload, sent.Payload) {
+			t.Fatal("\nsend=", sent.Payload, "\nget=", got.Payload, ", not same")
+		}
+		if !got.Valid() {
+			t.Fatalf("checksum = %x, want %x", got.Checksum, got.Sum())
+		}
+	}
+}
+
+func TestFrameMasking(t *testing.T) {
+	f := NewFrame(0x50, []byte{FrameStart, FrameEnd, FrameEscape})
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range data[1 : len(data)-1] {
+		if b == FrameStart || b == FrameEnd {
+			t.Fatalf("unmasked delimiter in %x", data)
+		}
+	}
+	var got Frame
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Payload, f.Payload) {
+		t.Fatalf("payload = %x, want %x", got.Payload, f.Payload)
+	}
+}
+
+func TestFrameUnmarshalMalformed(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{FrameEnd},
+		{FrameStart, FrameEnd},
+		{FrameStart, 'C', 0x50, FrameEnd},
+		{'C', 0x50, 0x01, 0x01, 0x00, FrameEnd},
+		{FrameStart, 'X', 0x50, 0x01, 0x01, 0x00, FrameEnd},
+	} {
+		var f Frame
+		if err := f.UnmarshalBinary(data); !errors.Is(err, ErrMalformedFrame) {
+			t.Fatalf("UnmarshalBinary(%x) error = %v, want %v", data, err, ErrMalformedFrame)
+		}
+	}
+}
+
+func TestSplitFrames(t *testing.T) {
+	payload := randomBytes(25)
+	frames, err := SplitFrames(0x61, payload, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want 3", len(frames))
+	}
+	var joined []byte
+	for i, f := range frames {
+		if f.Total != 3 || f.Index != byte(i) || !f.Valid() {
+			t.Fatalf("frame %d = total %d index %d valid %v", i, f.Total, f.Index, f.Valid())
+		}
+		if f.Last() != (i == 2) {
+			t.Fatalf("frame %d Last() = %v", i, f.Last())
+		}
+		joined = append(joined, f.Payload...)
+	}
+	if !bytes.Equal(joined, payload) {
+		t.Fatal("\nsend=", payload, "\nget=", joined, ", not same")
+	}
+
+	frames, err = SplitFrames(0x61, payload, 0)
+	if err != nil || len(frames) != 1 || frames[0].Total != 1 || frames[0].Index != 1 {
+		t.Fatalf("unsplit frames = %+v, %v", frames, err)
+	}
+}
+
+func TestDecoderResynchronize(t *testing.T) {
+	good, _ := NewFrame(0x50, []byte("status")).MarshalBinary()
+	var stream []byte
+	stream = append(stream, FrameEnd, 0x01, 0x02)              // garbage and a stray end byte
+	stream = append(stream, FrameStart, 'C', 0x50)             // frame cut by the next one
+	stream = append(stream, good...)                           // intact frame
+	stream = append(stream, FrameStart, 'C', FrameEnd)         // too short frame
+	stream = append(stream, good...)                           // intact frame
+	stream = append(stream, FrameStart, 'C', 0x50, 0x01, 0x01) // stream ends inside a frame
+
+	dec := NewDecoder(bytes.NewReader(stream))
+	for i, want := range []error{ErrMalformedFrame, nil, ErrMalformedFrame, nil, io.ErrUnexpectedEOF, io.EOF} {
+		f, err := dec.Decode()
+		if !errors.Is(err, want) && err != want {
+			t.Fatalf("Decode %d error = %v, want %v", i, err, want)
+		}
+		if want == nil && string(f.Payload) != "status" {
+			t.Fatalf("Decode %d payload = %q, want status", i, f.Payload)
+		}
+	}
+}
+
+func TestDecoderMaxPayloadSize(t *testing.T) {
+	large, _ := NewFrame(0x60, randomBytes(100)).MarshalBinary()
+	small, _ := NewFrame(0x50, []byte("status")).MarshalBinary()
+	dec := NewDecoder(bytes.NewReader(append(large, small...)))
+	dec.MaxPayloadSize = 50
+	if _, err := dec.Decode(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("error = %v, want %v", err, ErrFrameTooLarge)
+	}
+	if f, err := dec.Decode(); err != nil || string(f.Payload) != "status" {
+		t.Fatalf("Decode after oversized frame = %q, %v", f.Payload, err)
+	}
+}
+
+func TestDecoderMaxPayloadSizeEscapes(t *testing.T) {
+	escapes := append([]byte{FrameStart}, bytes.Repeat([]byte{FrameEscape}, 1000)...)
+	escaped, _ := NewFrame(0x61, bytes.Repeat([]byte{FrameEnd}, 50)).MarshalBinary()
+	small, _ := NewFrame(0x50, []byte("status")).MarshalBinary()
+	dec := NewDecoder(bytes.NewReader(append(append(escapes, escaped...), small...)))
+	dec.MaxPayloadSize = 50
+	if _, err := dec.Decode(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("error = %v, want %v", err, ErrFrameTooLarge)
+	}
+	if f, err := dec.Decode(); err != nil || len(f.Payload) != 50 {
+		t.Fatalf("Decode of fully escaped frame = %d bytes, %v", len(f.Payload), err)
+	}
+	if f, err := dec.Decode(); err != nil || string(f.Payload) != "status" {
+		t.Fatalf("Decode after escape run = %q, %v", f.Payload, err)
+	}
+}
+
+func FuzzDecoder(f *testing.F) {
+	good, _ := NewFrame(0x61, []byte("*IDN?\n")).MarshalBinary()
+	f.Add(good)
+	f.Add([]byte{FrameEnd})
+	f.Add([]byte{FrameStart, FrameEscape, FrameEnd})
+	f.Add(append([]byte{FrameStart, 'C'}, good...))
+	f.Add(append(append([]byte{FrameStart}, bytes.Repeat([]byte{FrameEscape}, 1000)...), FrameEnd))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dec := NewDecoder(bytes.NewReader(data))
+		dec.MaxPayloadSize = 256
+		for {
+			frame, err := dec.Decode()
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err != nil {
+				continue
+			}
+			// every accepted frame survives a round trip
+			raw, err := frame.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var again Frame
+			if err := again.UnmarshalBinary(raw); err != nil {
+				t.Fatalf("re-decoding %x: %v", raw, err)
+			}
+			if again.Command != frame.Command || again.Total != frame.Total || again.Index != frame.Index ||
+				again.Checksum != frame.Checksum || !bytes.Equal(again.Payload, frame.Payload) {
+				t.Fatalf("round trip of %x changed the frame", raw)
+			}
+		}
+	})
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	good, _ := NewFrame(0x50, nil).MarshalBinary()
+	f.Add(good)
+	f.Add([]byte{FrameStart, FrameEnd})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var frame Frame
+		frame.UnmarshalBinary(data)
+	})
+}
@@ -0,0 +1,189 @@
+package cell
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotInterference is returned when 0x83 payload holds no interference trace
+var ErrNotInterference = errors.New("input is not an interference XML source")
+
+// InterferencePower represents interferences data given by CellAdvisor,
+// the interference analyzer measurement of a Trace
+type InterferencePower struct {
+	Trace
+}
+
+// GetInterferencePower returning current interference power array
+// with json format
+func (cl *CellAdvisor) GetInterferencePower() (*InterferencePower, error) {
+	return cl.GetInterferencePowerContext(context.Background())
+}
+
+// GetInterferencePowerContext is like GetInterferencePower, but gives up once ctx is done
+func (cl *CellAdvisor) GetInterferencePowerContext(ctx context.Context) (*InterferencePower, error) {
+	ret, err := cl.TransactContext(ctx, 0x83, "")
+	if err != nil {
+		return nil, err
+	}
+	return ParseInterferencePower(ret)
+}
+
+// ParseInterferencePower parses 0x83 XML payload.
+// Values are read from attributes and leaf elements alike,
+// P<n> points are ordered by n, which must leave no gap
+// as points are spread evenly over the frequency axis.
+// Only Unit and the points are required, frequency plan and capture
+// time are left zero when missing or not understood
+func ParseInterferencePower(data []byte) (*InterferencePower, error) {
+	values, points, err := scanInterferenceXML(data)
+	if err != nil {
+		return nil, err
+	}
+	unit, ok := values["unit"]
+	if !ok || len(points) == 0 {
+		return nil, ErrNotInterference
+	}
+
+	indices := make([]int, 0, len(points))
+	for index := range points {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+
+	result := &InterferencePower{Trace{Unit: unit, Powertrace: make([]float32, len(indices))}}
+	for i, index := range indices {
+		if index != indices[0]+i {
+			return nil, fmt.Errorf("cell: interference point P%d missing", indices[0]+i)
+		}
+		power, err := strconv.ParseFloat(points[index], 32)
+		if err != nil {
+			return nil, err
+		}
+		result.Powertrace[i] = float32(power)
+	}
+
+	for _, field := range []struct {
+		target *float64
+		names  []string
+	}{
+		{&result.StartFrequency, []string{"startfreq", "startfrequency", "freqstart", "start"}},
+		{&result.StopFrequency, []string{"stopfreq", "stopfrequency", "freqstop", "stop"}},
+		{&result.CenterFrequency, []string{"centerfreq", "centerfrequency", "freqcenter", "center"}},
+		{&result.Span, []string{"span", "freqspan"}},
+		{&result.RBW, []string{"rbw", "resbw"}},
+	} {
+		for _, name := range field.names {
+			if frequency, err := parseFrequency(values[name]); err == nil {
+				*field.target = frequency
+				break
+			}
+		}
+	}
+	for _, name := range []string{"timestamp", "time", "date"} {
+		if timestamp, err := parseTimestamp(values[name]); err == nil {
+			result.Timestamp = timestamp
+			break
+		}
+	}
+	return result, nil
+}
+
+// scanInterferenceXML collects every attribute and leaf element text
+// into values, keyed by lower case name, and P<n> entries into points
+func scanInterferenceXML(data []byte) (map[string]string, map[int]string, error) {
+	values, points := map[string]string{}, map[int]string{}
+	set := func(name, value string) {
+		value = strings.TrimSpace(value)
+		if len(name) > 1 && (name[0] == 'P' || name[0] == 'p') {
+			if index, err := strconv.Atoi(name[1:]); err == nil {
+				points[index] = value
+				return
+			}
+		}
+		values[strings.ToLower(name)] = value
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(bytes.TrimRight(data, "\x00")))
+	decoder.Strict = false
+	var (
+		element string
+		text    []byte
+	)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return values, points, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				set(attr.Name.Local, attr.Value)
+			}
+			element, text = t.Name.Local, nil
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.EndElement:
+			// only leaf elements carry a value
+			if element == t.Name.Local && len(bytes.TrimSpace(text)) > 0 {
+				set(element, string(text))
+			}
+			element, text = "", nil
+		}
+	}
+}
+
+// frequencyUnits maps unit suffixes to their multiplier, longest first
+var frequencyUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"GHZ", 1e9}, {"MHZ", 1e6}, {"KHZ", 1e3}, {"HZ", 1},
+}
+
+// parseFrequency parses a frequency in Hz, accepting unit suffixes like 1.5 GHz
+func parseFrequency(value string) (float64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := 1.0
+	for _, unit := range frequencyUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, multiplier = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+	frequency, err := strconv.ParseFloat(value, 64)
+	return frequency * multiplier, err
+}
+
+// timestampLayouts are the capture time formats seen in device payloads
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006/01/02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+func parseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("cell: invalid timestamp " + strconv.Quote(value))
+	}
+	return time.Unix(seconds, 0), nil
+}
@@ -0,0 +1,80 @@
+package cell
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInterferencePowerAttributes(t *testing.T) {
+	payload := `<?xml version="1.0"?>
+<Interference Unit="dBm" StartFreq="1.8 GHz" StopFreq="1900000000" RBW="30kHz" Time="2015-03-02 10:20:30"
+ P2="-90.50" P0="-100.25" P3="-80.00" P1="-95.75"/>` + "\x00"
+	power, err := ParseInterferencePower([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if power.Unit != "dBm" {
+		t.Fatalf("unit = %s, want dBm", power.Unit)
+	}
+	want := []float32{-100.25, -95.75, -90.5, -80}
+	if len(power.Powertrace) != len(want) {
+		t.Fatalf("powertrace = %v, want %v", power.Powertrace, want)
+	}
+	for i := range want {
+		if power.Powertrace[i] != want[i] {
+			t.Fatalf("powertrace = %v, want %v", power.Powertrace, want)
+		}
+	}
+	if power.StartFrequency != 1.8e9 || power.StopFrequency != 1.9e9 || power.RBW != 30e3 {
+		t.Fatalf("frequency plan = %v %v %v", power.StartFrequency, power.StopFrequency, power.RBW)
+	}
+	if !power.Timestamp.Equal(time.Date(2015, 3, 2, 10, 20, 30, 0, time.UTC)) {
+		t.Fatalf("timestamp = %v", power.Timestamp)
+	}
+	frequencies := power.Frequencies()
+	if len(frequencies) != 4 || frequencies[0] != 1.8e9 || frequencies[3] != 1.9e9 {
+		t.Fatalf("frequencies = %v", frequencies)
+	}
+}
+
+func TestParseInterferencePowerElements(t *testing.T) {
+	payload := `<Interference><Unit>dBuV</Unit><CenterFreq>1000000</CenterFreq><Span>200</Span>
+<P1>-10.0</P1><P2>-20.0</P2><P3>-30.0</P3></Interference>`
+	power, err := ParseInterferencePower([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if power.Unit != "dBuV" || len(power.Powertrace) != 3 {
+		t.Fatalf("power = %+v", power)
+	}
+	frequencies := power.Frequencies()
+	if len(frequencies) != 3 || frequencies[0] != 999900 || frequencies[1] != 1e6 || frequencies[2] != 1000100 {
+		t.Fatalf("frequencies = %v", frequencies)
+	}
+}
+
+func TestParseInterferencePowerMetadataNotUnderstood(t *testing.T) {
+	payload := `<Interference Unit="dBm" Start="N/A" StopFreq="1.9 GHz" Time="12:00:01" Date="25/06/2015" P0="-100.0" P1="-90.0"/>`
+	power, err := ParseInterferencePower([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(power.Powertrace) != 2 || power.StartFrequency != 0 || power.StopFrequency != 1.9e9 || !power.Timestamp.IsZero() {
+		t.Fatalf("power = %+v", power)
+	}
+}
+
+func TestParseInterferencePowerGap(t *testing.T) {
+	payload := `<Interference Unit="dBm" StartFreq="1.8 GHz" StopFreq="1.9 GHz" P0="-100.0" P5="-90.0" P10="-80.0"/>`
+	if power, err := ParseInterferencePower([]byte(payload)); err == nil {
+		t.Fatalf("ParseInterferencePower accepted points P0, P5, P10 as %v", power.Powertrace)
+	}
+}
+
+func TestParseInterferencePowerInvalid(t *testing.T) {
+	for _, payload := range []string{"", "OK", `<Interference Unit="dBm"/>`, `<Interference P1="-1.0"/>`} {
+		if _, err := ParseInterferencePower([]byte(payload)); err == nil {
+			t.Fatalf("ParseInterferencePower(%q) accepted invalid payload", payload)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package cell
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Mode represents a measurement mode of CellAdvisor,
+// such as spectrum or interference analyzer, with its submodes
+type Mode struct {
+	Name     string
+	Submodes []string
+}
+
+// ParseModeCatalog parses :INST:CAT? reply, a comma separated list of
+// optionally quoted entries, either "MODE" or "MODE/SUBMODE".
+// Modes are returned in catalogue order, submodes grouped under their mode
+func ParseModeCatalog(catalog string) ([]Mode, error) {
+	var modes []Mode
+	index := map[string]int{}
+	for _, entry := range strings.Split(catalog, ",") {
+		entry = unquoteSCPIString(entry)
+		name, submode, _ := strings.Cut(entry, "/")
+		name, submode = strings.TrimSpace(name), strings.TrimSpace(submode)
+		if name == "" {
+			return nil, fmt.Errorf("cell: invalid mode catalog entry %q", entry)
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(modes)
+			index[name] = i
+			modes = append(modes, Mode{Name: name})
+		}
+		if submode != "" {
+			modes[i].Submodes = append(modes[i].Submodes, submode)
+		}
+	}
+	return modes, nil
+}
+
+// unquoteSCPIString strips surrounding whitespace and double quotes
+func unquoteSCPIString(s string) string {
+	s = strings.TrimSpace(s)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		return unquoted
+	}
+	return strings.Trim(s, `"`)
+}
+
+// Modes returning measurement modes the instrument supports
+func (cl *CellAdvisor) Modes() ([]Mode, error) {
+	return cl.ModesContext(context.Background())
+}
+
+// ModesContext is like Modes, but gives up once ctx is done
+func (cl *CellAdvisor) ModesContext(ctx context.Context) ([]Mode, error) {
+	ret, err := cl.QuerySCPIContext(ctx, ":INST:CAT?")
+	if err != nil {
+		return nil, err
+	}
+	return ParseModeCatalog(ret)
+}
+
+// ActiveMode returning the active mode as a catalogue entry,
+// "MODE" or "MODE/SUBMODE"
+func (cl *CellAdvisor) ActiveMode() (string, error) {
+	return cl.ActiveModeContext(context.Background())
+}
+
+// ActiveModeContext is like ActiveMode, but gives up once ctx is done
+func (cl *CellAdvisor) ActiveModeContext(ctx context.Context) (string, error) {
+	ret, err := cl.QuerySCPIContext(ctx, ":INST:SEL?")
+	return unquoteSCPIString(ret), err
+}
+
+// SetMode switches to the mode, given as "MODE" or "MODE/SUBMODE" like in
+// the catalogue, and returns once WaitOperationComplete reports the switch done
+// and the instrument reports the mode active
+func (cl *CellAdvisor) SetMode(mode string) error {
+	return cl.SetModeContext(context.Background(), mode)
+}
+
+// SetModeContext is like SetMode, but gives up once ctx is done
+func (cl *CellAdvisor) SetModeContext(ctx context.Context, mode string) error {
+	if err := cl.setSCPI(ctx, ":INST:SEL", strconv.Quote(mode)); err != nil {
+		return err
+	}
+	if err := cl.WaitOperationComplete(ctx); err != nil {
+		return err
+	}
+	active, err := cl.ActiveModeContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(active, mode) {
+		return fmt.Errorf("cell: mode %q requested, but %q is active", mode, active)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package cell
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseModeCatalog(t *testing.T) {
+	modes, err := ParseModeCatalog(`"SPECTRUM/CHANNEL POWER","SPECTRUM/OCCUPIED BW", "INTERFERENCE",SIGNAL/LTE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mode{
+		{"SPECTRUM", []string{"CHANNEL POWER", "OCCUPIED BW"}},
+		{"INTERFERENCE", nil},
+		{"SIGNAL", []string{"LTE"}},
+	}
+	if !reflect.DeepEqual(modes, want) {
+		t.Fatalf("modes = %+v, want %+v", modes, want)
+	}
+	if _, err := ParseModeCatalog(`"SPECTRUM",,"INTERFERENCE"`); err == nil {
+		t.Fatal("ParseModeCatalog accepted an empty entry")
+	}
+}
+
+func TestSetMode(t *testing.T) {
+	active := "SPECTRUM"
+	cl := fakeSCPIDevice(t, func(scpicmd string) string {
+		switch {
+		case scpicmd == ":INST:CAT?":
+			return `"SPECTRUM","INTERFERENCE/SPECTROGRAM"` + "\n"
+		case scpicmd == ":INST:SEL?":
+			return `"` + active + `"` + "\n"
+		case scpicmd == "*OPC?":
+			return "1\n"
+		case strings.HasPrefix(scpicmd, ":INST:SEL "):
+			// the instrument knows no mode outside its catalogue
+			if mode := unquoteSCPIString(strings.TrimPrefix(scpicmd, ":INST:SEL ")); mode != "UNKNOWN" {
+				active = mode
+			}
+		}
+		return ""
+	})
+	defer cl.Close()
+
+	if modes, err := cl.Modes(); err != nil || len(modes) != 2 || modes[1].Submodes[0] != "SPECTROGRAM" {
+		t.Fatalf("Modes = %+v, %v", modes, err)
+	}
+	if err := cl.SetMode("INTERFERENCE/SPECTROGRAM"); err != nil {
+		t.Fatal(err)
+	}
+	if mode, err := cl.ActiveMode(); err != nil || mode != "INTERFERENCE/SPECTROGRAM" {
+		t.Fatalf("ActiveMode = %q, %v", mode, err)
+	}
+	if err := cl.SetMode("UNKNOWN"); err == nil {
+		t.Fatal("SetMode succeeded while the mode stayed inactive")
+	}
+}
@@ -0,0 +1,64 @@
+package cell
+
+import (
+	"context"
+	"time"
+)
+
+// Defaults of SetOperationCompleteWait
+const (
+	DefaultOPCPollInterval = 200 * time.Millisecond
+	DefaultOPCTimeout      = 30 * time.Second
+)
+
+// SetOperationCompleteWait sets how often WaitOperationComplete queries
+// *OPC? and how long it waits at most, zero timeout waits until ctx is done
+func (cl *CellAdvisor) SetOperationCompleteWait(pollInterval, timeout time.Duration) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.opcPollInterval = pollInterval
+	cl.opcTimeout = timeout
+}
+
+// WaitOperationComplete returns once *OPC? reports every pending operation
+// complete, polling while the instrument answers 0.
+// It returns ErrTimeout once the configured timeout elapsed
+func (cl *CellAdvisor) WaitOperationComplete(ctx context.Context) error {
+	cl.mu.Lock()
+	interval, timeout := cl.opcPollInterval, cl.opcTimeout
+	cl.mu.Unlock()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	for {
+		complete, err := cl.QuerySCPIBoolContext(ctx, "*OPC?")
+		if err != nil || complete {
+			return err
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrTimeout
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// SendSCPIAndWait sends SCPI command like SendSCPI,
+// then waits until the instrument completed it
+func (cl *CellAdvisor) SendSCPIAndWait(scpicmd string) (int, error) {
+	return cl.SendSCPIAndWaitContext(context.Background(), scpicmd)
+}
+
+// SendSCPIAndWaitContext is like SendSCPIAndWait, but gives up once ctx is done
+func (cl *CellAdvisor) SendSCPIAndWaitContext(ctx context.Context, scpicmd string) (int, error) {
+	n, err := cl.SendSCPIContext(ctx, scpicmd)
+	if err != nil {
+		return n, err
+	}
+	return n, cl.WaitOperationComplete(ctx)
+}
@@ -0,0 +1,56 @@
+package cell
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitOperationComplete(t *testing.T) {
+	var polls, pending int
+	cl := fakeSCPIDevice(t, func(scpicmd string) string {
+		switch scpicmd {
+		case ":INIT:IMM":
+			pending = 3
+		case "*OPC?":
+			polls++
+			if pending > 0 {
+				pending--
+				return "0\n"
+			}
+			return "1\n"
+		}
+		return ""
+	})
+	defer cl.Close()
+	cl.SetOperationCompleteWait(time.Millisecond, time.Second)
+
+	if _, err := cl.SendSCPIAndWait(":INIT:IMM"); err != nil {
+		t.Fatal(err)
+	}
+	if polls != 4 {
+		t.Fatalf("*OPC? sent %d times, want 4", polls)
+	}
+}
+
+func TestWaitOperationCompleteTimeout(t *testing.T) {
+	cl := fakeSCPIDevice(t, func(scpicmd string) string {
+		if scpicmd == "*OPC?" {
+			return "0\n"
+		}
+		return ""
+	})
+	defer cl.Close()
+
+	cl.SetOperationCompleteWait(time.Millisecond, 20*time.Millisecond)
+	if err := cl.WaitOperationComplete(context.Background()); err != ErrTimeout {
+		t.Fatalf("error = %v, want %v", err, ErrTimeout)
+	}
+
+	cl.SetOperationCompleteWait(time.Millisecond, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cl.WaitOperationComplete(ctx); err != context.Canceled {
+		t.Fatalf("error = %v, want %v", err, context.Canceled)
+	}
+}
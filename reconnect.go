@@ -0,0 +1,361 @@
+package cell
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Defaults of ReconnectOptions
+const (
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// ReconnectOptions configures a ReconnectingCellAdvisor
+type ReconnectOptions struct {
+	// Dial describes how to connect, like NewCellAdvisorWithOptions
+	Dial DialOptions
+	// MinBackoff is the wait before redialing after the first failed dial
+	// or dropped connection, doubling on every further failure up to
+	// MaxBackoff until a request goes through. Waits are jittered
+	// between half and all of their length
+	MinBackoff, MaxBackoff time.Duration
+	// MaxAttempts limits dials made by a single call, whether they fail
+	// or the connection is dropped, no limit if zero
+	MaxAttempts int
+	// RetryIdempotent retries status, screen and interference power
+	// requests on the new connection once the old one failed
+	RetryIdempotent bool
+	// OnConnect is called after every successful dial
+	OnConnect func()
+	// OnDisconnect is called with the error a connection was dropped for
+	OnDisconnect func(err error)
+}
+
+// ReconnectingCellAdvisor is a CellAdvisor which drops its connection on
+// EOF, reset and timeout errors, and redials on the next call with
+// exponential backoff. It connects on first use and is safe for concurrent use
+type ReconnectingCellAdvisor struct {
+	ip   string
+	opts ReconnectOptions
+	// done is closed by Close, ending backoff waits
+	done chan struct{}
+
+	mu sync.Mutex
+	// cl is nil while disconnected
+	cl     *CellAdvisor
+	closed bool
+	// dialing is closed once the dial in progress ends, nil if none is
+	dialing chan struct{}
+	// failures counts failed dials and dropped connections
+	// since the last request that went through
+	failures int
+}
+
+// ErrClosed is returned by a ReconnectingCellAdvisor used after Close
+var ErrClosed = errors.New("cell: reconnecting CellAdvisor is closed")
+
+// NewReconnectingCellAdvisor creates ReconnectingCellAdvisor with given
+// ip address, no connection is made before the first call
+func NewReconnectingCellAdvisor(ip string, opts ReconnectOptions) *ReconnectingCellAdvisor {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = DefaultMaxBackoff
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	return &ReconnectingCellAdvisor{ip: ip, opts: opts, done: make(chan struct{})}
+}
+
+// IsConnectionError reports whether err leaves the connection unusable,
+// either lost, with a reply still on its way or with the rest of a
+// broken reply left to read
+func IsConnectionError(err error) bool {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, ErrTimeout), errors.Is(err, context.Canceled),
+		errors.Is(err, net.ErrClosed), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, ErrMalformedFrame), errors.Is(err, ErrFrameTooLarge),
+		errors.Is(err, ErrTooManyFrames):
+		return true
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// backoff returning the jittered wait after the given number of failures
+func (r *ReconnectingCellAdvisor) backoff(failures int) time.Duration {
+	d := r.opts.MinBackoff
+	for i := 1; i < failures && d < r.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.opts.MaxBackoff {
+		d = r.opts.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// CellAdvisor returning the current connection, dialing with backoff
+// while there is none
+func (r *ReconnectingCellAdvisor) CellAdvisor(ctx context.Context) (*CellAdvisor, error) {
+	var dials int
+	return r.connect(ctx, &dials)
+}
+
+// connect returns the current connection, or dials a new one counting
+// dials in *dials. Callers wait for a dial already in progress, which is
+// made without r.mu held so that Close is not held up by it
+func (r *ReconnectingCellAdvisor) connect(ctx context.Context, dials *int) (*CellAdvisor, error) {
+	var lastErr error
+	for {
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if r.cl != nil {
+			cl := r.cl
+			r.mu.Unlock()
+			return cl, nil
+		}
+		if dialing := r.dialing; dialing != nil {
+			r.mu.Unlock()
+			select {
+			case <-dialing:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		dialing := make(chan struct{})
+		r.dialing = dialing
+		failures := r.failures
+		r.mu.Unlock()
+
+		cl, err := r.dial(ctx, failures, dials)
+
+		r.mu.Lock()
+		r.dialing = nil
+		close(dialing)
+		switch {
+		case err == nil && r.closed:
+			r.mu.Unlock()
+			cl.Close()
+			return nil, ErrClosed
+		case err == nil:
+			r.cl = cl
+			r.mu.Unlock()
+			if r.opts.OnConnect != nil {
+				r.opts.OnConnect()
+			}
+			return cl, nil
+		case err == ErrClosed || err == ctx.Err():
+			// interrupted while waiting to dial
+			r.mu.Unlock()
+			if lastErr != nil && err != ErrClosed {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			// cut short by the caller, not a failure of the device
+			r.mu.Unlock()
+			return nil, err
+		}
+		r.failures++
+		r.mu.Unlock()
+		lastErr = err
+		if r.opts.MaxAttempts > 0 && *dials >= r.opts.MaxAttempts {
+			return nil, err
+		}
+	}
+}
+
+// dial waits the backoff due after failures, then dials once,
+// both given up once ctx is done
+func (r *ReconnectingCellAdvisor) dial(ctx context.Context, failures int, dials *int) (*CellAdvisor, error) {
+	if failures > 0 {
+		select {
+		case <-time.After(r.backoff(failures)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-r.done:
+			return nil, ErrClosed
+		}
+	}
+	*dials++
+	return NewCellAdvisorContext(ctx, r.ip, r.opts.Dial)
+}
+
+// drop closes cl after it failed with err, unless it was replaced already
+func (r *ReconnectingCellAdvisor) drop(cl *CellAdvisor, err error) {
+	r.mu.Lock()
+	if r.cl != cl {
+		r.mu.Unlock()
+		return
+	}
+	r.cl = nil
+	r.failures++
+	r.mu.Unlock()
+	cl.Close()
+	if r.opts.OnDisconnect != nil {
+		r.opts.OnDisconnect(err)
+	}
+}
+
+// Do runs fn on the current connection, dropping the connection if fn
+// fails with a connection error. Idempotent calls are then run again
+// on a new connection when RetryIdempotent is set, until MaxAttempts
+// dials were made by the call
+func (r *ReconnectingCellAdvisor) Do(ctx context.Context, idempotent bool, fn func(cl *CellAdvisor) error) error {
+	var dials int
+	for {
+		cl, err := r.connect(ctx, &dials)
+		if err != nil {
+			return err
+		}
+		err = fn(cl)
+		if !IsConnectionError(err) {
+			r.mu.Lock()
+			r.failures = 0
+			r.mu.Unlock()
+			return err
+		}
+		r.drop(cl, err)
+		if !idempotent || !r.opts.RetryIdempotent || ctx.Err() != nil ||
+			r.opts.MaxAttempts > 0 && dials >= r.opts.MaxAttempts {
+			return err
+		}
+	}
+}
+
+// Close closes the current connection, later calls return ErrClosed.
+// A dial in progress is closed once it completes
+func (r *ReconnectingCellAdvisor) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	cl := r.cl
+	r.cl = nil
+	r.mu.Unlock()
+	if cl == nil {
+		return nil
+	}
+	return cl.Close()
+}
+
+// Transact is like CellAdvisor.Transact, never retried
+func (r *ReconnectingCellAdvisor) Transact(cmd byte, payload string) ([]byte, error) {
+	return r.TransactContext(context.Background(), cmd, payload)
+}
+
+// TransactContext is like Transact, but gives up once ctx is done
+func (r *ReconnectingCellAdvisor) TransactContext(ctx context.Context, cmd byte, payload string) (ret []byte, err error) {
+	err = r.Do(ctx, false, func(cl *CellAdvisor) error {
+		ret, err = cl.TransactContext(ctx, cmd, payload)
+		return err
+	})
+	return ret, err
+}
+
+// SendSCPI is like CellAdvisor.SendSCPI, never retried
+func (r *ReconnectingCellAdvisor) SendSCPI(scpicmd string) (int, error) {
+	return r.SendSCPIContext(context.Background(), scpicmd)
+}
+
+// SendSCPIContext is like SendSCPI, but gives up once ctx is done
+func (r *ReconnectingCellAdvisor) SendSCPIContext(ctx context.Context, scpicmd string) (n int, err error) {
+	err = r.Do(ctx, false, func(cl *CellAdvisor) error {
+		n, err = cl.SendSCPIContext(ctx, scpicmd)
+		return err
+	})
+	return n, err
+}
+
+// QuerySCPI is like CellAdvisor.QuerySCPI, never retried
+func (r *ReconnectingCellAdvisor) QuerySCPI(scpicmd string) (string, error) {
+	return r.QuerySCPIContext(context.Background(), scpicmd)
+}
+
+// QuerySCPIContext is like QuerySCPI, but gives up once ctx is done
+func (r *ReconnectingCellAdvisor) QuerySCPIContext(ctx context.Context, scpicmd string) (ret string, err error) {
+	err = r.Do(ctx, false, func(cl *CellAdvisor) error {
+		ret, err = cl.QuerySCPIContext(ctx, scpicmd)
+		return err
+	})
+	return ret, err
+}
+
+// GetStatusMessage is like CellAdvisor.GetStatusMessage, retried if idempotent calls are
+func (r *ReconnectingCellAdvisor) GetStatusMessage() (string, error) {
+	return r.GetStatusMessageContext(context.Background())
+}
+
+// GetStatusMessageContext is like GetStatusMessage, but gives up once ctx is done
+func (r *ReconnectingCellAdvisor) GetStatusMessageContext(ctx context.Context) (ret string, err error) {
+	err = r.Do(ctx, true, func(cl *CellAdvisor) error {
+		ret, err = cl.GetStatusMessageContext(ctx)
+		return err
+	})
+	return ret, err
+}
+
+// GetStatus is like CellAdvisor.GetStatus, retried if idempotent calls are
+func (r *ReconnectingCellAdvisor) GetStatus() (*DeviceStatus, error) {
+	return r.GetStatusContext(context.Background())
+}
+
+// GetStatusContext is like GetStatus, but gives up once ctx is done
+func (r *ReconnectingCellAdvisor) GetStatusContext(ctx context.Context) (status *DeviceStatus, err error) {
+	err = r.Do(ctx, true, func(cl *CellAdvisor) error {
+		status, err = cl.GetStatusContext(ctx)
+		return err
+	})
+	return status, err
+}
+
+// GetScreen is like CellAdvisor.GetScreen, retried if idempotent calls are
+func (r *ReconnectingCellAdvisor) GetScreen() ([]byte, error) {
+	return r.GetScreenContext(context.Background())
+}
+
+// GetScreenContext is like GetScreen, but gives up once ctx is done
+func (r *ReconnectingCellAdvisor) GetScreenContext(ctx context.Context) (screen []byte, err error) {
+	err = r.Do(ctx, true, func(cl *CellAdvisor) error {
+		screen, err = cl.GetScreenContext(ctx)
+		return err
+	})
+	return screen, err
+}
+
+// GetInterferencePower is like CellAdvisor.GetInterferencePower, retried if idempotent calls are
+func (r *ReconnectingCellAdvisor) GetInterferencePower() (*InterferencePower, error) {
+	return r.GetInterferencePowerContext(context.Background())
+}
+
+// GetInterferencePowerContext is like GetInterferencePower, but gives up once ctx is done
+func (r *ReconnectingCellAdvisor) GetInterferencePowerContext(ctx context.Context) (power *InterferencePower, err error) {
+	err = r.Do(ctx, true, func(cl *CellAdvisor) error {
+		power, err = cl.GetInterferencePowerContext(ctx)
+		return err
+	})
+	return power, err
+}
@@ -0,0 +1,312 @@
+package cell
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyDevice counts dials through its DialOptions, refusing the first
+// refusals of them, and echoes frames on every connection it accepts once
+// identified, closing a connection after dropAfter messages if non-zero
+type flakyDevice struct {
+	mu        sync.Mutex
+	dials     int
+	refusals  int
+	dropAfter int
+}
+
+func (d *flakyDevice) options() DialOptions {
+	return DialOptions{DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.dials++; d.dials <= d.refusals {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+		}
+		client, device := net.Pipe()
+		go d.serve(device, d.dropAfter)
+		return client, nil
+	}}
+}
+
+func (d *flakyDevice) serve(conn net.Conn, dropAfter int) {
+	defer conn.Close()
+	if answerIdentification(conn) != nil {
+		return
+	}
+	dec, enc := NewDecoder(conn), NewEncoder(conn)
+	for messages := 1; ; messages++ {
+		f, err := dec.Decode()
+		if err != nil || messages == dropAfter {
+			return
+		}
+		if err := enc.Encode(f); err != nil {
+			return
+		}
+	}
+}
+
+func TestReconnectRetryIdempotent(t *testing.T) {
+	device := &flakyDevice{refusals: 2, dropAfter: 2}
+	var connects, disconnects int
+	r := NewReconnectingCellAdvisor("flaky", ReconnectOptions{
+		Dial:            device.options(),
+		MinBackoff:      time.Millisecond,
+		MaxBackoff:      4 * time.Millisecond,
+		RetryIdempotent: true,
+		OnConnect:       func() { connects++ },
+		OnDisconnect: func(err error) {
+			if err != io.EOF {
+				t.Errorf("disconnected for %v, want %v", err, io.EOF)
+			}
+			disconnects++
+		},
+	})
+	defer r.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := r.GetStatusMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// two refused dials, then connections dropped on their second message,
+	// during the second and the third call
+	if device.dials != 5 || connects != 3 || disconnects != 2 {
+		t.Fatalf("dials = %d, connects = %d, disconnects = %d", device.dials, connects, disconnects)
+	}
+}
+
+func TestReconnectNotIdempotent(t *testing.T) {
+	device := &flakyDevice{dropAfter: 1}
+	r := NewReconnectingCellAdvisor("flaky", ReconnectOptions{Dial: device.options(), RetryIdempotent: true})
+	defer r.Close()
+
+	if _, err := r.Transact(0x61, "*RST\n"); err != io.EOF {
+		t.Fatalf("error = %v, want %v", err, io.EOF)
+	}
+	device.dropAfter = 0
+	if ret, err := r.Transact(0x61, "*IDN?\n"); err != nil || string(ret) != "*IDN?\n" {
+		t.Fatalf("Transact after reconnect = %q, %v", ret, err)
+	}
+	if device.dials != 2 {
+		t.Fatalf("dials = %d, want 2", device.dials)
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	device := &flakyDevice{refusals: 100}
+	r := NewReconnectingCellAdvisor("flaky", ReconnectOptions{Dial: device.options(), MinBackoff: time.Millisecond, MaxAttempts: 3})
+	if _, err := r.GetScreen(); !errors.Is(err, ErrConnectionRefused) {
+		t.Fatalf("error = %v, want %v", err, ErrConnectionRefused)
+	}
+	if device.dials != 3 {
+		t.Fatalf("dials = %d, want 3", device.dials)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	r = NewReconnectingCellAdvisor("flaky", ReconnectOptions{Dial: device.options(), MinBackoff: 5 * time.Millisecond})
+	if _, err := r.GetScreenContext(ctx); !errors.Is(err, ErrConnectionRefused) {
+		t.Fatalf("error = %v, want %v", err, ErrConnectionRefused)
+	}
+	r.Close()
+	if _, err := r.GetScreen(); err != ErrClosed {
+		t.Fatalf("error after Close = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	r := NewReconnectingCellAdvisor("flaky", ReconnectOptions{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	for failures, max := range map[int]time.Duration{1: 10, 2: 20, 3: 40, 4: 50, 10: 50} {
+		max *= time.Millisecond
+		if d := r.backoff(failures); d < max/2 || d > max {
+			t.Fatalf("backoff(%d) = %v, want between %v and %v", failures, d, max/2, max)
+		}
+	}
+}
+
+func TestReconnectDroppedOnConnect(t *testing.T) {
+	device := &flakyDevice{dropAfter: 1}
+	r := NewReconnectingCellAdvisor("flaky", ReconnectOptions{
+		Dial:            device.options(),
+		MinBackoff:      time.Millisecond,
+		MaxAttempts:     3,
+		RetryIdempotent: true,
+	})
+	defer r.Close()
+
+	// every connection is dropped on its first request
+	if _, err := r.GetStatusMessage(); err != io.EOF {
+		t.Fatalf("error = %v, want %v", err, io.EOF)
+	}
+	if device.dials != 3 || r.failures != 3 {
+		t.Fatalf("dials = %d, failures = %d, want 3 and 3", device.dials, r.failures)
+	}
+	// backoff keeps growing across calls until a request goes through
+	if _, err := r.GetStatusMessage(); err != io.EOF || r.failures != 6 {
+		t.Fatalf("error = %v, failures = %d, want %v and 6", err, r.failures, io.EOF)
+	}
+	device.dropAfter = 0
+	if _, err := r.GetStatusMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if r.failures != 0 {
+		t.Fatalf("failures after a request went through = %d, want 0", r.failures)
+	}
+}
+
+func TestReconnectCloseWhileDialing(t *testing.T) {
+	dialing, release, gone := make(chan struct{}), make(chan struct{}), make(chan error, 1)
+	r := NewReconnectingCellAdvisor("slow", ReconnectOptions{Dial: DialOptions{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			close(dialing)
+			<-release
+			client, device := net.Pipe()
+			go func() {
+				defer device.Close()
+				if err := answerIdentification(device); err != nil {
+					gone <- err
+					return
+				}
+				_, err := NewDecoder(device).Decode()
+				gone <- err
+			}()
+			return client, nil
+		},
+	}})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := r.GetStatusMessage()
+		errs <- err
+	}()
+	<-dialing
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close waited for the dial in progress")
+	}
+	close(release)
+	if err := <-errs; err != ErrClosed {
+		t.Fatalf("error = %v, want %v", err, ErrClosed)
+	}
+	// the connection dialed after Close is closed without being used
+	if err := <-gone; err != io.EOF {
+		t.Fatalf("device read %v, want %v", err, io.EOF)
+	}
+}
+
+func TestReconnectConcurrentCallersShareDial(t *testing.T) {
+	device := &flakyDevice{}
+	r := NewReconnectingCellAdvisor("flaky", ReconnectOptions{Dial: device.options()})
+	defer r.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.GetStatusMessage(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if device.dials != 1 {
+		t.Fatalf("dials = %d, want 1", device.dials)
+	}
+}
+
+func TestReconnectBrokenFrameInReply(t *testing.T) {
+	var dials int
+	r := NewReconnectingCellAdvisor("broken", ReconnectOptions{Dial: DialOptions{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dials++
+			client, device := net.Pipe()
+			go func(first bool) {
+				defer device.Close()
+				if answerIdentification(device) != nil {
+					return
+				}
+				dec, enc := NewDecoder(device), NewEncoder(device)
+				for {
+					f, err := dec.Decode()
+					if err != nil {
+						return
+					}
+					if !first {
+						enc.Encode(f)
+						continue
+					}
+					// the first reply breaks between the frames of a message
+					first = false
+					head := Frame{Command: f.Command, Total: 2, Index: 0, Payload: []byte("stale")}
+					head.Checksum = head.Sum()
+					tail := Frame{Command: f.Command, Total: 2, Index: 1, Payload: []byte("reply")}
+					tail.Checksum = tail.Sum()
+					enc.Encode(head)
+					device.Write([]byte{FrameStart, 'X', f.Command, 2, 1, 0, FrameEnd})
+					enc.Encode(tail)
+				}
+			}(dials == 1)
+			return client, nil
+		},
+	}})
+	defer r.Close()
+
+	if _, err := r.GetStatusMessage(); !errors.Is(err, ErrMalformedFrame) {
+		t.Fatalf("error = %v, want %v", err, ErrMalformedFrame)
+	}
+	// the rest of the broken reply is not taken for the next one
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ret, err := r.TransactContext(ctx, 0x50, "ping"); err != nil || string(ret) != "ping" {
+		t.Fatalf("Transact after broken reply = %q, %v", ret, err)
+	}
+	if dials != 2 {
+		t.Fatalf("dials = %d, want 2", dials)
+	}
+}
+
+func TestReconnectDialHonorsContext(t *testing.T) {
+	r := NewReconnectingCellAdvisor("unreachable", ReconnectOptions{Dial: DialOptions{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}})
+	defer r.Close()
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err := r.GetStatusContext(ctx)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrDialTimeout) && err != context.DeadlineExceeded {
+				t.Fatalf("error = %v, want %v", err, ErrDialTimeout)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("dial outlived the caller's context")
+		}
+	}
+	if r.failures != 0 {
+		t.Fatalf("failures = %d, want 0 for dials cut short by the caller", r.failures)
+	}
+}
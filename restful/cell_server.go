@@ -2,7 +2,9 @@
 package restful
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"expvar"
 	"fmt"
 	"log"
@@ -75,43 +77,64 @@ type cellServer struct {
 // NewCellAdvisorServer returning automatic RESTful API server set
 // user could access directly api/screen/*, and api/scpi/*
 // after deploy retuning object to sever
-func NewCellAdvisorServer(threadNumber int, cellAddr string, pollPeriod time.Duration) *mux.Router {
+// it returns an error if any of the CellAdvisor connections could not be made
+func NewCellAdvisorServer(threadNumber int, cellAddr string, pollPeriod time.Duration) (*mux.Router, error) {
+	return NewCellAdvisorServerWithOptions(threadNumber, cellAddr, pollPeriod, cell.DialOptions{})
+}
+
+// NewCellAdvisorServerWithOptions is like NewCellAdvisorServer,
+// connecting to CellAdvisor as described by opts
+func NewCellAdvisorServerWithOptions(threadNumber int, cellAddr string, pollPeriod time.Duration, opts cell.DialOptions) (*mux.Router, error) {
 
 	screenCache := pollScreenCache{time.Now(), []byte{}}
 
 	requestChannel := make(chan *pollRequest, threadNumber)
 
-	server := cellServer{screenCache, requestChannel, pollPeriod, sync.RWMutex{}}
+	server := &cellServer{screenCache: screenCache, requestChannel: requestChannel, pollPeriod: pollPeriod}
 
-	celladvisor_tcp_connections_array := make([]cell.CellAdvisor, threadNumber)
+	celladvisor_tcp_connections_array := make([]*cell.CellAdvisor, threadNumber)
 
-	died := make(chan int)
 	for i := 0; i < threadNumber; i++ {
-		celladvisor_tcp_connections_array[i] = cell.NewCellAdvisor(cellAddr)
-		go server.poller(&celladvisor_tcp_connections_array[i], i, died)
-		go func() {
-			for {
-				select {
-				// dead thread retuning celladvisor_tcp_connections_array offset
-				case offset := <-died:
-					log.Printf("Thread(%d) Restarting", offset)
-					cell := &celladvisor_tcp_connections_array[offset]
-					cell.Reinitialize()
-					go server.poller(cell, offset, died)
-				}
+		advisor, err := cell.NewCellAdvisorWithOptions(cellAddr, opts)
+		if err != nil {
+			for _, opened := range celladvisor_tcp_connections_array[:i] {
+				opened.Close()
 			}
-		}()
+			return nil, err
+		}
+		celladvisor_tcp_connections_array[i] = advisor
+	}
+
+	died := make(chan int)
+	for i, advisor := range celladvisor_tcp_connections_array {
+		go server.poller(advisor, i, died)
 	}
+	go func() {
+		// dead thread retuning celladvisor_tcp_connections_array offset
+		for offset := range died {
+			log.Printf("Thread(%d) Restarting", offset)
+			cell := celladvisor_tcp_connections_array[offset]
+			if err := cell.Reinitialize(); err != nil {
+				log.Printf("Thread(%d) Restart failed: %s", offset, err.Error())
+				go func(offset int) {
+					time.Sleep(server.pollPeriod)
+					died <- offset
+				}(offset)
+				continue
+			}
+			go server.poller(cell, offset, died)
+		}
+	}()
 
 	rtr := mux.NewRouter()
 	rtr.Handle("/api/{command}.json", server)
 	rtr.Handle("/api/screen/{command}", server)
 	rtr.Handle("/api/scpi/{command}", server).Methods("POST")
 
-	return rtr
+	return rtr, nil
 }
 
-func (server cellServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (server *cellServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var values url.Values
 	params := mux.Vars(r)
 	command := params["command"]
@@ -168,9 +191,9 @@ func (server *cellServer) poller(cell *cell.CellAdvisor, threadNumber int, died
 					data = "keyp value missing"
 				} else {
 					scpicmd := fmt.Sprintf("KEYP:%s", request.args.Get("value"))
-					numsent, err = cell.SendSCPI(scpicmd)
+					numsent, err = sendSCPI(cell, scpicmd, request.args)
 					if err != nil {
-						code = http.StatusInternalServerError
+						code = scpiErrorCode(err)
 						data = err.Error()
 					} else {
 						data = fmt.Sprintf("keypad: %d byte sent", numsent)
@@ -182,9 +205,9 @@ func (server *cellServer) poller(cell *cell.CellAdvisor, threadNumber int, died
 					data = "x,y value missing"
 				} else {
 					scpicmd := fmt.Sprintf("KEYP %s %s", request.args.Get("x"), request.args.Get("y"))
-					numsent, err = cell.SendSCPI(scpicmd)
+					numsent, err = sendSCPI(cell, scpicmd, request.args)
 					if err != nil {
-						code = http.StatusInternalServerError
+						code = scpiErrorCode(err)
 						data = err.Error()
 					} else {
 						data = fmt.Sprintf("touch: %d byte sent", numsent)
@@ -216,6 +239,18 @@ func (server *cellServer) poller(cell *cell.CellAdvisor, threadNumber int, died
 					code = http.StatusInternalServerError
 					data = err.Error()
 				}
+			case "device_info":
+				data, err = cell.GetDeviceInfo()
+				if err != nil {
+					code = http.StatusInternalServerError
+					data = err.Error()
+				}
+			case "status":
+				data, err = cell.GetStatus()
+				if err != nil {
+					code = http.StatusInternalServerError
+					data = err.Error()
+				}
 			case "heartbeat":
 				data, err = cell.GetStatusMessage()
 				if err != nil {
@@ -234,10 +269,10 @@ func (server *cellServer) poller(cell *cell.CellAdvisor, threadNumber int, died
 			log.Printf("Thread(%d): %s", threadNumber, data)
 			server.mutex.Unlock()
 		}
-		//Check Error Status == EOF
+		//Check Error Status == EOF, or a timeout leaving a late reply behind
 		if err != nil {
-			switch err.Error() {
-			case "EOF":
+			switch {
+			case isConnectionError(err):
 				log.Println("Connection loses on ", threadNumber, ", Poller exited")
 				return
 			default:
@@ -247,6 +282,48 @@ func (server *cellServer) poller(cell *cell.CellAdvisor, threadNumber int, died
 	}
 }
 
+// isConnectionError reports whether err ends a poller, its connection
+// being lost or out of step with the replies
+var isConnectionError = cell.IsConnectionError
+
+// errInvalidTimeout is returned for a timeout argument not in time.ParseDuration form
+var errInvalidTimeout = errors.New("timeout value invalid")
+
+// sendSCPI sends scpicmd, draining instrument error queue when check=true is given
+// and waiting for the instrument to complete it when wait=true is given,
+// for no longer than timeout if given
+func sendSCPI(cell *cell.CellAdvisor, scpicmd string, args url.Values) (int, error) {
+	ctx := context.Background()
+	if value := args.Get("timeout"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return 0, errInvalidTimeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	send := cell.SendSCPI
+	if args.Get("check") == "true" {
+		send = cell.SendSCPIChecked
+	}
+	numsent, err := send(scpicmd)
+	if err != nil || args.Get("wait") != "true" {
+		return numsent, err
+	}
+	return numsent, cell.WaitOperationComplete(ctx)
+}
+
+// scpiErrorCode returns 400 for commands the instrument rejected
+// or invalid arguments, 500 otherwise
+func scpiErrorCode(err error) int {
+	var scpiErr *cell.SCPIError
+	if errors.As(err, &scpiErr) || err == errInvalidTimeout {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
 func createRequest(command string, args url.Values) *pollRequest {
 	return &pollRequest{command, args, make(chan pollResult)}
 }
@@ -265,5 +342,4 @@ func receiveResult(pipe <-chan pollResult) *pollResult {
 		receiveSucessCount.Add(1)
 		return &result
 	}
-	return nil
 }
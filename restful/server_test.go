@@ -4,9 +4,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tomahawk28/cell/celltest"
 )
 
 type testData struct {
@@ -20,7 +24,8 @@ type testData struct {
 }
 
 var (
-	rtr           = NewCellAdvisorServer(4, "10.82.26.12", time.Second*10)
+	device        *celltest.Server
+	rtr           *mux.Router
 	testDataArray = []testData{
 		testData{
 			subject:      "touch : Missing y value",
@@ -94,9 +99,42 @@ var (
 			expectedCode: http.StatusOK,
 			expectedType: "application/json",
 		},
+		testData{
+			subject:      "device_info : ",
+			url:          "/api/device_info.json",
+			method:       "GET",
+			argument:     map[string]string{},
+			expected:     "Model",
+			expectedCode: http.StatusOK,
+			expectedType: "application/json",
+		},
+		testData{
+			subject:      "status : ",
+			url:          "/api/status.json",
+			method:       "GET",
+			argument:     map[string]string{},
+			expected:     "Measurement",
+			expectedCode: http.StatusOK,
+			expectedType: "application/json",
+		},
 	}
 )
 
+func TestMain(m *testing.M) {
+	device = celltest.NewServer()
+	rtr = mustCellAdvisorServer(NewCellAdvisorServerWithOptions(4, device.IP, time.Second*10, device.DialOptions()))
+	code := m.Run()
+	device.Close()
+	os.Exit(code)
+}
+
+func mustCellAdvisorServer(rtr *mux.Router, err error) *mux.Router {
+	if err != nil {
+		panic(err)
+	}
+	return rtr
+}
+
 func createQuery(argument map[string]string) url.Values {
 	u := url.Values{}
 	for k, v := range argument {
@@ -159,3 +197,115 @@ func BenchmarkProcessingRESTfulRequest(b *testing.B) {
 		}
 	}
 }
+
+func TestSCPICommandsReachDevice(t *testing.T) {
+	v := createQuery(map[string]string{"value": "PRESET"})
+	r, _ := http.NewRequest("POST", "/api/scpi/keyp", strings.NewReader(v.Encode()))
+	r.Form = v
+	w := httptest.NewRecorder()
+	rtr.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d", w.Code, http.StatusOK)
+	}
+	// the reply is sent before the device is known to have read the command
+	deadline := time.Now().Add(time.Second)
+	for {
+		for _, scpicmd := range device.SCPICommands() {
+			if scpicmd == "KEYP:PRESET" {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("device received %q, want KEYP:PRESET", device.SCPICommands())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func getCode(rtr *mux.Router, url string) int {
+	r, _ := http.NewRequest("GET", url, nil)
+	w := httptest.NewRecorder()
+	rtr.ServeHTTP(w, r)
+	return w.Code
+}
+
+// waitConnections waits for device to have accepted n connections
+func waitConnections(t *testing.T, device *celltest.Server, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for device.Connections() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("device accepted %d connections, want %d", device.Connections(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPollerRestartsAfterConnectionLoss(t *testing.T) {
+	device := celltest.NewServer()
+	defer device.Close()
+	rtr := mustCellAdvisorServer(NewCellAdvisorServerWithOptions(2, device.IP, 20*time.Millisecond, device.DialOptions()))
+	waitConnections(t, device, 2)
+
+	// a reply cut short ends the poller, which is restarted on a new connection
+	device.AddFault(celltest.Fault{Kind: celltest.FaultTruncate, Command: 0x83, Count: 1})
+	if code := getCode(rtr, "/api/interference_power.json"); code != http.StatusInternalServerError {
+		t.Fatalf("code = %d, want %d", code, http.StatusInternalServerError)
+	}
+	waitConnections(t, device, 3)
+
+	// while refused, restarts are retried until the device is back
+	device.SetScenario(celltest.Scenario{Faults: []celltest.Fault{
+		{Kind: celltest.FaultRefuse, Command: 0x83, Count: 1, Duration: celltest.Duration(100 * time.Millisecond)},
+	}})
+	if code := getCode(rtr, "/api/interference_power.json"); code != http.StatusInternalServerError {
+		t.Fatalf("code = %d, want %d", code, http.StatusInternalServerError)
+	}
+	waitConnections(t, device, 5)
+	for i := 0; i < 4; i++ {
+		if code := getCode(rtr, "/api/interference_power.json"); code != http.StatusOK {
+			t.Fatalf("code after restart = %d, want %d", code, http.StatusOK)
+		}
+	}
+}
+
+func TestPollerRestartsAfterOperationCompleteTimeout(t *testing.T) {
+	device := celltest.NewServer()
+	defer device.Close()
+	rtr := mustCellAdvisorServer(NewCellAdvisorServerWithOptions(2, device.IP, 20*time.Millisecond, device.DialOptions()))
+	waitConnections(t, device, 2)
+	// both connections asked *IDN? on connect
+	deadline := time.Now().Add(time.Second)
+	for len(device.SCPICommands()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("device received %q, want *IDN? twice", device.SCPICommands())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the *OPC? reply following KEYP arrives after the wait timed out,
+	// the poller ends instead of reading it as the next reply
+	device.AddFault(celltest.Fault{Kind: celltest.FaultLatency, Command: 0x61, After: 1, Count: 1, Duration: celltest.Duration(200 * time.Millisecond)})
+	v := createQuery(map[string]string{"value": "PRESET", "wait": "true", "timeout": "50ms"})
+	r, _ := http.NewRequest("POST", "/api/scpi/keyp", strings.NewReader(v.Encode()))
+	r.Form = v
+	w := httptest.NewRecorder()
+	rtr.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("code = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	waitConnections(t, device, 3)
+
+	v.Set("timeout", "soon")
+	r, _ = http.NewRequest("POST", "/api/scpi/keyp", strings.NewReader(v.Encode()))
+	r.Form = v
+	w = httptest.NewRecorder()
+	rtr.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("code for invalid timeout = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	for i := 0; i < 4; i++ {
+		if code := getCode(rtr, "/api/device_info.json"); code != http.StatusOK {
+			t.Fatalf("code after restart = %d, want %d", code, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package cell
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SendSCPI sends SCPI commands to CellAdvisor devices
+// (http://en.wikipedia.org/wiki/Standard_Commands_for_Programmable_Instruments)
+func (cl *CellAdvisor) SendSCPI(scpicmd string) (int, error) {
+	return cl.SendSCPIContext(context.Background(), scpicmd)
+}
+
+// SendSCPIContext is like SendSCPI, but gives up once ctx is done
+func (cl *CellAdvisor) SendSCPIContext(ctx context.Context, scpicmd string) (int, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.sendSCPI(ctx, scpicmd, cl.checkErrors)
+}
+
+// SendSCPIChecked sends SCPI command and drains the instrument's error queue
+// right after it, whether checked mode is on or not.
+// It returns a *SCPIError if the instrument reported any
+func (cl *CellAdvisor) SendSCPIChecked(scpicmd string) (int, error) {
+	return cl.SendSCPICheckedContext(context.Background(), scpicmd)
+}
+
+// SendSCPICheckedContext is like SendSCPIChecked, but gives up once ctx is done
+func (cl *CellAdvisor) SendSCPICheckedContext(ctx context.Context, scpicmd string) (int, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.sendSCPI(ctx, scpicmd, true)
+}
+
+// SetErrorChecking turns checked mode on or off. In checked mode
+// SendSCPI drains SYST:ERR? after every command and returns a *SCPIError
+// if the instrument rejected it. Errors left in the queue by unchecked
+// commands are reported against the next checked one
+func (cl *CellAdvisor) SetErrorChecking(enabled bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.checkErrors = enabled
+}
+
+func (cl *CellAdvisor) sendSCPI(ctx context.Context, scpicmd string, check bool) (int, error) {
+	num, err := cl.sendMessage(ctx, 0x61, scpicmd+"\n")
+	if err != nil || !check {
+		return num, err
+	}
+	return num, cl.drainSCPIErrors(ctx, scpicmd)
+}
+
+// maxSCPIErrorQueue bounds how many SYST:ERR? queries drain a single command
+const maxSCPIErrorQueue = 32
+
+// SCPIError represents an entry of the instrument's SCPI error queue
+type SCPIError struct {
+	// Code is the SCPI error number, e.g. -113
+	Code int
+	// Message is the error description given by the instrument
+	Message string
+	// Command is the SCPI command which caused the error
+	Command string
+}
+
+func (e *SCPIError) Error() string {
+	return fmt.Sprintf("cell: %s: scpi error %d, %q", e.Command, e.Code, e.Message)
+}
+
+// drainSCPIErrors empties the error queue and returns its first entry
+func (cl *CellAdvisor) drainSCPIErrors(ctx context.Context, scpicmd string) error {
+	var first *SCPIError
+	for i := 0; i < maxSCPIErrorQueue; i++ {
+		ret, err := cl.transact(ctx, 0x61, "SYST:ERR?\n", false)
+		if err != nil {
+			return err
+		}
+		scpiErr, err := parseSCPIError(trimSCPIResponse(string(ret)))
+		if err != nil {
+			return err
+		}
+		if scpiErr.Code == 0 {
+			break
+		}
+		if first == nil {
+			scpiErr.Command = scpicmd
+			first = scpiErr
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	return first
+}
+
+// parseSCPIError parses a SYST:ERR? reply like -113,"Undefined header"
+func parseSCPIError(ret string) (*SCPIError, error) {
+	fields := strings.SplitN(ret, ",", 2)
+	code, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return nil, fmt.Errorf("cell: SYST:ERR?: invalid reply %q", ret)
+	}
+	scpiErr := &SCPIError{Code: code}
+	if len(fields) == 2 {
+		scpiErr.Message = strings.Trim(strings.TrimSpace(fields[1]), "\"")
+	}
+	return scpiErr, nil
+}
+
+// QuerySCPI sends SCPI command and returns the instrument's reply
+// without its terminator. Commands which are not queries (not ending in '?')
+// are only sent, and an empty reply is returned
+func (cl *CellAdvisor) QuerySCPI(scpicmd string) (string, error) {
+	return cl.QuerySCPIContext(context.Background(), scpicmd)
+}
+
+// QuerySCPIContext is like QuerySCPI, but gives up once ctx is done
+func (cl *CellAdvisor) QuerySCPIContext(ctx context.Context, scpicmd string) (string, error) {
+	if !isSCPIQuery(scpicmd) {
+		_, err := cl.SendSCPIContext(ctx, scpicmd)
+		return "", err
+	}
+	ret, err := cl.TransactContext(ctx, 0x61, scpicmd+"\n")
+	if err != nil {
+		return "", err
+	}
+	return trimSCPIResponse(string(ret)), nil
+}
+
+// QuerySCPIFloat sends SCPI query and parses its reply as a number
+func (cl *CellAdvisor) QuerySCPIFloat(scpicmd string) (float64, error) {
+	return cl.QuerySCPIFloatContext(context.Background(), scpicmd)
+}
+
+// QuerySCPIFloatContext is like QuerySCPIFloat, but gives up once ctx is done
+func (cl *CellAdvisor) QuerySCPIFloatContext(ctx context.Context, scpicmd string) (float64, error) {
+	ret, err := cl.QuerySCPIContext(ctx, scpicmd)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseFloat(ret, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cell: %s: invalid number %q", scpicmd, ret)
+	}
+	return value, nil
+}
+
+// QuerySCPIInt sends SCPI query and parses its reply as an integer,
+// integral values in exponent notation (1.0E+01) are accepted
+func (cl *CellAdvisor) QuerySCPIInt(scpicmd string) (int64, error) {
+	return cl.QuerySCPIIntContext(context.Background(), scpicmd)
+}
+
+// QuerySCPIIntContext is like QuerySCPIInt, but gives up once ctx is done
+func (cl *CellAdvisor) QuerySCPIIntContext(ctx context.Context, scpicmd string) (int64, error) {
+	ret, err := cl.QuerySCPIContext(ctx, scpicmd)
+	if err != nil {
+		return 0, err
+	}
+	if value, err := strconv.ParseInt(ret, 10, 64); err == nil {
+		return value, nil
+	}
+	if value, err := strconv.ParseFloat(ret, 64); err == nil && value == float64(int64(value)) {
+		return int64(value), nil
+	}
+	return 0, fmt.Errorf("cell: %s: invalid integer %q", scpicmd, ret)
+}
+
+// QuerySCPIBool sends SCPI query and parses its reply as a boolean,
+// 1/0 and ON/OFF are accepted
+func (cl *CellAdvisor) QuerySCPIBool(scpicmd string) (bool, error) {
+	return cl.QuerySCPIBoolContext(context.Background(), scpicmd)
+}
+
+// QuerySCPIBoolContext is like QuerySCPIBool, but gives up once ctx is done
+func (cl *CellAdvisor) QuerySCPIBoolContext(ctx context.Context, scpicmd string) (bool, error) {
+	ret, err := cl.QuerySCPIContext(ctx, scpicmd)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToUpper(ret) {
+	case "1", "ON", "+1":
+		return true, nil
+	case "0", "OFF", "+0":
+		return false, nil
+	}
+	return false, fmt.Errorf("cell: %s: invalid boolean %q", scpicmd, ret)
+}
+
+// isSCPIQuery reports whether the last command of a ';' separated
+// SCPI message is a query, i.e. its header ends in '?'
+func isSCPIQuery(scpicmd string) bool {
+	commands := strings.Split(strings.TrimSpace(scpicmd), ";")
+	header := strings.Fields(commands[len(commands)-1])
+	return len(header) > 0 && strings.HasSuffix(header[0], "?")
+}
+
+func trimSCPIResponse(ret string) string {
+	return strings.TrimSpace(strings.TrimRight(ret, "\r\n\x00"))
+}
@@ -0,0 +1,129 @@
+package cell
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeSCPIDevice returns a CellAdvisor connected to a device
+// answering every 0x61 frame with reply(command), nothing if reply is empty
+func fakeSCPIDevice(t *testing.T, reply func(scpicmd string) string) *CellAdvisor {
+	return pipeCellAdvisor(t, func(device net.Conn) {
+		defer device.Close()
+		dec, enc := NewDecoder(device), NewEncoder(device)
+		for {
+			f, err := dec.Decode()
+			if err != nil {
+				return
+			}
+			if f.Command != 0x61 {
+				continue
+			}
+			if ret := reply(strings.TrimSuffix(string(f.Payload), "\n")); ret != "" {
+				if err := enc.Encode(NewFrame(0x61, []byte(ret))); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+func TestQuerySCPI(t *testing.T) {
+	var sent []string
+	cl := fakeSCPIDevice(t, func(scpicmd string) string {
+		sent = append(sent, scpicmd)
+		switch scpicmd {
+		case "*IDN?":
+			return "JDSU,JD745A,123456,1.0.0\r\n"
+		case "FREQ:CENT?":
+			return "+1.850000000E+09\n"
+		case "SWE:POIN?":
+			return "1.001E+03\n"
+		case "AVER:STAT?":
+			return "ON\n"
+		}
+		return ""
+	})
+	defer cl.Close()
+
+	if ret, err := cl.QuerySCPI("*IDN?"); err != nil || ret != "JDSU,JD745A,123456,1.0.0" {
+		t.Fatalf("QuerySCPI = %q, %v", ret, err)
+	}
+	if ret, err := cl.QuerySCPI("FREQ:CENT 1GHZ"); err != nil || ret != "" {
+		t.Fatalf("QuerySCPI non-query = %q, %v", ret, err)
+	}
+	if ret, err := cl.QuerySCPIFloat("FREQ:CENT?"); err != nil || ret != 1.85e9 {
+		t.Fatalf("QuerySCPIFloat = %v, %v", ret, err)
+	}
+	if ret, err := cl.QuerySCPIInt("SWE:POIN?"); err != nil || ret != 1001 {
+		t.Fatalf("QuerySCPIInt = %v, %v", ret, err)
+	}
+	if ret, err := cl.QuerySCPIBool("AVER:STAT?"); err != nil || !ret {
+		t.Fatalf("QuerySCPIBool = %v, %v", ret, err)
+	}
+	if _, err := cl.QuerySCPIFloat("*IDN?"); err == nil {
+		t.Fatal("QuerySCPIFloat accepted a non-numeric reply")
+	}
+	if len(sent) != 6 || sent[1] != "FREQ:CENT 1GHZ" {
+		t.Fatalf("sent = %q", sent)
+	}
+}
+
+func TestIsSCPIQuery(t *testing.T) {
+	for scpicmd, want := range map[string]bool{
+		"*IDN?":                true,
+		"TRAC:DATA? TRACE1":    true,
+		"FREQ:CENT 1GHZ;SPAN?": true,
+		"KEYP:MODE":            false,
+		"FREQ:CENT? ;SPAN 1":   false,
+		"":                     false,
+	} {
+		if got := isSCPIQuery(scpicmd); got != want {
+			t.Errorf("isSCPIQuery(%q) = %v, want %v", scpicmd, got, want)
+		}
+	}
+}
+
+func TestSendSCPIErrorChecking(t *testing.T) {
+	var queue []string
+	cl := fakeSCPIDevice(t, func(scpicmd string) string {
+		switch {
+		case scpicmd == "SYST:ERR?":
+			if len(queue) == 0 {
+				return `0,"No error"`
+			}
+			ret := queue[0]
+			queue = queue[1:]
+			return ret
+		case strings.HasPrefix(scpicmd, "KEYP:BOGUS"):
+			queue = append(queue, `-113,"Undefined header"`, `-100,"Command error"`)
+		}
+		return ""
+	})
+	defer cl.Close()
+
+	cl.SetErrorChecking(true)
+	if _, err := cl.SendSCPI("KEYP:MODE"); err != nil {
+		t.Fatalf("SendSCPI error = %v", err)
+	}
+	_, err := cl.SendSCPI("KEYP:BOGUS")
+	scpiErr, ok := err.(*SCPIError)
+	if !ok {
+		t.Fatalf("SendSCPI error = %v, want *SCPIError", err)
+	}
+	if scpiErr.Code != -113 || scpiErr.Message != "Undefined header" || scpiErr.Command != "KEYP:BOGUS" {
+		t.Fatalf("SCPIError = %+v", scpiErr)
+	}
+	if len(queue) != 0 {
+		t.Fatalf("error queue not drained: %q", queue)
+	}
+
+	cl.SetErrorChecking(false)
+	if _, err := cl.SendSCPI("KEYP:BOGUS"); err != nil {
+		t.Fatalf("unchecked SendSCPI error = %v", err)
+	}
+	if _, err := cl.SendSCPIChecked("KEYP:MODE"); err == nil {
+		t.Fatal("SendSCPIChecked did not report the queued error")
+	}
+}
@@ -0,0 +1,168 @@
+package cell
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trace represents a measured spectrum with its frequency plan
+type Trace struct {
+	// Number is the instrument trace number, zero for measurement results
+	Number int `json:"Number,omitempty"`
+	// Unit represents real unit of following float array
+	Unit string `json:"Unit"`
+	// PowerTrace is measured power array based on frequency plan
+	Powertrace []float32 `json:"Powertrace"`
+	// StartFrequency is the frequency of the first point in Hz
+	StartFrequency float64 `json:"StartFrequency,omitempty"`
+	// StopFrequency is the frequency of the last point in Hz
+	StopFrequency float64 `json:"StopFrequency,omitempty"`
+	// CenterFrequency is the center of the sweep in Hz
+	CenterFrequency float64 `json:"CenterFrequency,omitempty"`
+	// Span is the width of the sweep in Hz
+	Span float64 `json:"Span,omitempty"`
+	// RBW is the resolution bandwidth in Hz
+	RBW float64 `json:"RBW,omitempty"`
+	// VBW is the video bandwidth in Hz
+	VBW float64 `json:"VBW,omitempty"`
+	// Detector is the detector type used for the sweep
+	Detector Detector `json:"Detector,omitempty"`
+	// Timestamp is the capture time, zero if unknown
+	Timestamp time.Time `json:"Timestamp"`
+}
+
+// Frequencies returning frequency in Hz of every Powertrace point,
+// it returns nil if the trace has no frequency plan
+func (t *Trace) Frequencies() []float64 {
+	start, stop := t.StartFrequency, t.StopFrequency
+	if start == 0 && stop == 0 {
+		if t.CenterFrequency == 0 {
+			return nil
+		}
+		start, stop = t.CenterFrequency-t.Span/2, t.CenterFrequency+t.Span/2
+	}
+	return linearFrequencies(start, stop, len(t.Powertrace))
+}
+
+func linearFrequencies(start, stop float64, points int) []float64 {
+	if points == 0 {
+		return nil
+	}
+	frequencies := make([]float64, points)
+	if points == 1 {
+		frequencies[0] = start
+		return frequencies
+	}
+	step := (stop - start) / float64(points-1)
+	for i := range frequencies {
+		frequencies[i] = start + step*float64(i)
+	}
+	return frequencies
+}
+
+// powerUnits maps SCPI unit names to the spelling used in Trace.Unit
+var powerUnits = map[string]string{
+	"DBM":  "dBm",
+	"DBMV": "dBmV",
+	"DBUV": "dBuV",
+	"DBUA": "dBuA",
+	"V":    "V",
+	"W":    "W",
+}
+
+// GetTrace returning full resolution trace, numbered from 1, along with
+// the frequency plan and settings it was measured with.
+// Trace data is requested as little endian REAL,32, then the data format
+// and byte order are set back the way they were
+func (cl *CellAdvisor) GetTrace(number int) (*Trace, error) {
+	return cl.GetTraceContext(context.Background(), number)
+}
+
+// GetTraceContext is like GetTrace, but gives up once ctx is done
+func (cl *CellAdvisor) GetTraceContext(ctx context.Context, number int) (*Trace, error) {
+	if number < 1 {
+		return nil, fmt.Errorf("cell: invalid trace number %d", number)
+	}
+	trace := &Trace{Number: number}
+	var err error
+	if trace.StartFrequency, trace.StopFrequency, err = cl.StartStopContext(ctx); err != nil {
+		return nil, err
+	}
+	trace.CenterFrequency = (trace.StartFrequency + trace.StopFrequency) / 2
+	trace.Span = trace.StopFrequency - trace.StartFrequency
+	if trace.RBW, err = cl.RBWContext(ctx); err != nil {
+		return nil, err
+	}
+	if trace.VBW, err = cl.VBWContext(ctx); err != nil {
+		return nil, err
+	}
+	if trace.Detector, err = cl.DetectorContext(ctx); err != nil {
+		return nil, err
+	}
+	unit, err := cl.QuerySCPIContext(ctx, ":UNIT:POW?")
+	if err != nil {
+		return nil, err
+	}
+	if trace.Unit = powerUnits[strings.ToUpper(unit)]; trace.Unit == "" {
+		trace.Unit = unit
+	}
+
+	ret, err := cl.transferTraceData(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	trace.Timestamp = time.Now()
+	if trace.Powertrace, err = parseTraceData(ret); err != nil {
+		return nil, err
+	}
+	return trace, nil
+}
+
+// transferTraceData reads trace data as REAL,32 in swapped byte order,
+// then restores the data format and byte order it found
+func (cl *CellAdvisor) transferTraceData(ctx context.Context, number int) (ret []byte, err error) {
+	format, err := cl.QuerySCPIContext(ctx, ":FORM:DATA?")
+	if err != nil {
+		return nil, err
+	}
+	order, err := cl.QuerySCPIContext(ctx, ":FORM:BORD?")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cl.SendSCPIContext(ctx, ":FORM:DATA REAL,32;:FORM:BORD SWAP"); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, restoreErr := cl.SendSCPIContext(ctx, fmt.Sprintf(":FORM:DATA %s;:FORM:BORD %s", format, order))
+		if err == nil {
+			err = restoreErr
+		}
+	}()
+	return cl.TransactContext(ctx, 0x61, fmt.Sprintf(":TRAC:DATA? TRACE%d\n", number))
+}
+
+// parseTraceData decodes a little endian REAL,32 block,
+// or comma separated values from instruments ignoring the format request
+func parseTraceData(ret []byte) ([]float32, error) {
+	if reply := trimSCPIResponse(string(ret)); !strings.HasPrefix(reply, "#") {
+		fields := strings.Split(reply, ",")
+		points := make([]float32, len(fields))
+		for i, field := range fields {
+			value, err := strconv.ParseFloat(strings.TrimSpace(field), 32)
+			if err != nil {
+				return nil, fmt.Errorf("cell: invalid trace point %q", field)
+			}
+			points[i] = float32(value)
+		}
+		return points, nil
+	}
+	data, err := ParseBlock(ret)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeReal32(data, binary.LittleEndian)
+}
@@ -0,0 +1,91 @@
+package cell
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// traceDevice answers the queries GetTrace issues, trace data from data,
+// and reports data format commands on the returned channel
+func traceDevice(t *testing.T, data string) (*CellAdvisor, <-chan string) {
+	formats := make(chan string, 4)
+	cl := fakeSCPIDevice(t, func(scpicmd string) string {
+		switch scpicmd {
+		case ":FORM:DATA?":
+			return "ASC,8\n"
+		case ":FORM:BORD?":
+			return "NORM\n"
+		case ":SENS:FREQ:STAR?":
+			return "+8.000000000E+08\n"
+		case ":SENS:FREQ:STOP?":
+			return "+9.000000000E+08\n"
+		case ":SENS:BAND:RES?":
+			return "30000\n"
+		case ":SENS:BAND:VID?":
+			return "10000\n"
+		case ":SENS:DET:FUNC?":
+			return "rms\n"
+		case ":UNIT:POW?":
+			return "DBM\n"
+		case ":TRAC:DATA? TRACE2":
+			return data
+		}
+		if strings.HasPrefix(scpicmd, ":FORM:") {
+			formats <- scpicmd
+		}
+		return ""
+	})
+	return cl, formats
+}
+
+// expectFormats fails unless the trace data format was set for the
+// transfer, then restored
+func expectFormats(t *testing.T, formats <-chan string) {
+	for _, want := range []string{":FORM:DATA REAL,32;:FORM:BORD SWAP", ":FORM:DATA ASC,8;:FORM:BORD NORM"} {
+		if got := <-formats; got != want {
+			t.Fatalf("sent %q, want %q", got, want)
+		}
+	}
+}
+
+func TestGetTrace(t *testing.T) {
+	raw := make([]byte, 12)
+	for i, v := range []float32{-90.5, -45, -100.25} {
+		binary.LittleEndian.PutUint32(raw[i*4:], math.Float32bits(v))
+	}
+	for _, data := range []string{"#212" + string(raw) + "\n", "-9.05E+01,-45,-100.25\n"} {
+		cl, formats := traceDevice(t, data)
+		trace, err := cl.GetTrace(2)
+		cl.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectFormats(t, formats)
+		if trace.Number != 2 || trace.Unit != "dBm" || trace.Detector != DetectorRMS || trace.RBW != 30e3 || trace.VBW != 10e3 {
+			t.Fatalf("trace settings = %+v", trace)
+		}
+		if trace.CenterFrequency != 850e6 || trace.Span != 100e6 || trace.Timestamp.IsZero() {
+			t.Fatalf("trace frequency plan = %+v", trace)
+		}
+		if len(trace.Powertrace) != 3 || trace.Powertrace[0] != -90.5 || trace.Powertrace[2] != -100.25 {
+			t.Fatalf("Powertrace = %v", trace.Powertrace)
+		}
+		if f := trace.Frequencies(); f[0] != 800e6 || f[1] != 850e6 || f[2] != 900e6 {
+			t.Fatalf("Frequencies = %v", f)
+		}
+	}
+}
+
+func TestGetTraceInvalid(t *testing.T) {
+	cl, formats := traceDevice(t, "#215abc\n")
+	defer cl.Close()
+	if _, err := cl.GetTrace(0); err == nil {
+		t.Fatal("GetTrace accepted trace 0")
+	}
+	if _, err := cl.GetTrace(2); err == nil {
+		t.Fatal("GetTrace accepted truncated block")
+	}
+	expectFormats(t, formats)
+}